@@ -0,0 +1,441 @@
+// Command dggarchiver-config checks and documents dggarchiver config files
+// without connecting to the services they configure, such as Docker, the
+// SQLite database or the streaming platforms. The config itself is still
+// read from NATS KV when nats.config.bucket is set and from K8s when
+// $CONFIG_CONFIGMAP or $CONFIG_SECRET is set, and drift asks the running
+// services for their config over NATS.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"gopkg.in/yaml.v3"
+
+	"github.com/DggHQ/dggarchiver-config/misc"
+	"github.com/DggHQ/dggarchiver-config/unified"
+)
+
+var (
+	configFlag    = flag.String("config", "", "config files or directories, defaults to $CONFIG or config.yaml")
+	profileFlag   = flag.String("profile", "", "config profile to apply, defaults to $CONFIG_PROFILE")
+	serviceFlag   = flag.String("service", "", "comma separated services to load, defaults to the ones in the config file")
+	timeoutFlag   = flag.Duration("timeout", 2*time.Second, "time to wait for the running services to answer")
+	recipientFlag = flag.String("recipient", "", "comma separated age public keys to encrypt to, defaults to the ones of $CONFIG_AGE_KEY")
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: dggarchiver-config [flags] <command>
+
+Commands:
+  validate       validate the config of every service
+  validate-all   validate the whole config, including the settings the
+                 services must agree on
+  print          print the effective config with secrets masked
+  explain <key>  describe a config key, e.g. notifier.platforms.kick.url
+  schema         print the JSON Schema of the config file
+  migrate        upgrade old config files to the current version in place
+  encrypt        encrypt the secret values of the config files with age
+  decrypt        decrypt the secret values of the config files
+  drift          compare the configs of the running services with each
+                 other and with the config files
+
+Flags:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	var err error
+	switch flag.Arg(0) {
+	case "validate":
+		err = validate()
+	case "validate-all":
+		err = validateAll()
+	case "print":
+		err = printConfig()
+	case "explain":
+		if flag.NArg() != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		err = explain(flag.Arg(1))
+	case "schema":
+		err = schema()
+	case "migrate":
+		err = migrate()
+	case "encrypt":
+		err = encrypt()
+	case "decrypt":
+		err = decrypt()
+	case "drift":
+		err = drift()
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func configPath() string {
+	return misc.NewOptions(misc.WithConfigFile(*configFlag)).ConfigPath()
+}
+
+// selected returns the services picked with -service, or the ones with a
+// section in the config files.
+func selected() ([]unified.Service, error) {
+	var names []string
+	if *serviceFlag != "" {
+		names = strings.Split(*serviceFlag, ",")
+	} else {
+		doc, err := misc.NewOptions(misc.WithConfigFile(*configFlag), misc.WithoutConnect()).ReadConfig()
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(doc.Root.Content); i += 2 {
+			names = append(names, doc.Root.Content[i].Value)
+		}
+	}
+
+	var selected []unified.Service
+	for _, svc := range unified.Services {
+		for _, name := range names {
+			if strings.TrimSpace(name) == svc.Name {
+				selected = append(selected, svc)
+			}
+		}
+	}
+	if len(selected) == 0 {
+		return nil, errors.New("no services to load")
+	}
+	return selected, nil
+}
+
+func load(svc unified.Service) (any, error) {
+	return svc.Load(misc.WithConfigFile(configPath()), misc.WithProfile(*profileFlag), misc.WithoutConnect())
+}
+
+func validate() error {
+	selected, err := selected()
+	if err != nil {
+		return err
+	}
+
+	failed := false
+	for _, svc := range selected {
+		if _, err := load(svc); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", svc.Name, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("%s: ok\n", svc.Name)
+	}
+	if failed {
+		return errors.New("config is invalid")
+	}
+	return nil
+}
+
+func validateAll() error {
+	report, err := unified.ValidateAll(misc.WithConfigFile(configPath()), misc.WithProfile(*profileFlag))
+	if report != nil {
+		for _, w := range report.Warnings {
+			fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("ok")
+	return nil
+}
+
+func printConfig() error {
+	selected, err := selected()
+	if err != nil {
+		return err
+	}
+
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	seen := map[string]bool{}
+	for _, svc := range selected {
+		cfg, err := load(svc)
+		if err != nil {
+			return fmt.Errorf("%s: %w", svc.Name, err)
+		}
+		// Sections shared by services, like nats, are printed once
+		node := misc.RedactedNode(cfg)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if key := node.Content[i].Value; !seen[key] {
+				seen[key] = true
+				doc.Content = append(doc.Content, node.Content[i], node.Content[i+1])
+			}
+		}
+	}
+
+	enc := yaml.NewEncoder(os.Stdout)
+	enc.SetIndent(2)
+	return enc.Encode(doc)
+}
+
+func explain(key string) error {
+	var keys []misc.KeyInfo
+	seen := map[string]bool{}
+	for _, svc := range unified.Services {
+		for _, info := range misc.Describe(svc.Config()) {
+			if !seen[info.Key] {
+				seen[info.Key] = true
+				keys = append(keys, info)
+			}
+		}
+	}
+
+	var names []string
+	for _, info := range keys {
+		if info.Key != key {
+			names = append(names, info.Key)
+			continue
+		}
+
+		fmt.Printf("%s (%s)\n", info.Key, info.Type)
+		if info.Description != "" {
+			fmt.Printf("  %s\n", info.Description)
+		}
+		if info.Default != "" {
+			fmt.Printf("  default: %s\n", info.Default)
+		}
+		if len(info.Allowed) > 0 {
+			fmt.Printf("  allowed: %s\n", strings.Join(info.Allowed, ", "))
+		}
+		if info.Rules != "" {
+			fmt.Printf("  rules: %s\n", info.Rules)
+		}
+		if len(info.Aliases) > 0 {
+			fmt.Printf("  deprecated aliases: %s\n", strings.Join(info.Aliases, ", "))
+		}
+		if info.Secret {
+			fmt.Println("  secret: masked when printed, can reference file://, env:// or secret://")
+		}
+		if info.Type != "section" {
+			fmt.Printf("  env: %s\n", misc.EnvName(strings.Split(info.Key, ".")))
+		}
+
+		for _, child := range keys {
+			if strings.HasPrefix(child.Key, key+".") && !strings.Contains(child.Key[len(key)+1:], ".") {
+				fmt.Printf("  %s (%s): %s\n", child.Key, child.Type, child.Description)
+			}
+		}
+		return nil
+	}
+
+	if suggestion := misc.Closest(key, names); suggestion != "" {
+		return fmt.Errorf("unknown config key %s, did you mean %s?", key, suggestion)
+	}
+	return fmt.Errorf("unknown config key %s", key)
+}
+
+func schema() error {
+	b, err := unified.SchemaJSON()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Println(string(b))
+	return err
+}
+
+// migrate rewrites every config file older than misc.ConfigVersion,
+// including the ones read through include keys and directories. Files
+// encrypted by SOPS are skipped, rewriting them would break their MAC, and
+// are migrated when loaded instead.
+func migrate() error {
+	return rewriteFiles(func(file string, root *yaml.Node) (bool, error) {
+		if misc.IsSOPS(root) {
+			fmt.Printf("%s: encrypted by SOPS, skipped, migrate it with sops edit\n", file)
+			return false, nil
+		}
+		version, deprecations, err := misc.Migrate(root, file)
+		if err != nil {
+			return false, err
+		}
+		if version == misc.ConfigVersion {
+			fmt.Printf("%s: up to date\n", file)
+			return false, nil
+		}
+		for _, d := range deprecations {
+			fmt.Println(d)
+		}
+		fmt.Printf("%s: migrated from version %d to %d\n", file, version, misc.ConfigVersion)
+		return true, nil
+	})
+}
+
+// encrypt encrypts the secret values of the config files with age.
+func encrypt() error {
+	var keys []string
+	if *recipientFlag != "" {
+		keys = strings.Split(*recipientFlag, ",")
+	}
+	recipients, err := misc.AgeRecipients(keys)
+	if err != nil {
+		return err
+	}
+	if len(recipients) == 0 {
+		return errors.New("no age recipient to encrypt to, set -recipient")
+	}
+
+	return cryptSecrets("encrypted", func(value string) (string, bool, error) {
+		if misc.IsEncrypted(value) || strings.Contains(value, "${") || isSecretRef(value) {
+			return value, false, nil
+		}
+		encrypted, err := misc.EncryptValue(value, recipients)
+		return encrypted, err == nil, err
+	})
+}
+
+// decrypt decrypts the secret values of the config files encrypted by
+// encrypt.
+func decrypt() error {
+	identities, err := misc.AgeIdentities()
+	if err != nil {
+		return err
+	}
+	return cryptSecrets("decrypted", func(value string) (string, bool, error) {
+		if !misc.IsEncrypted(value) {
+			return value, false, nil
+		}
+		decrypted, err := misc.DecryptValue(value, identities)
+		return decrypted, err == nil, err
+	})
+}
+
+// cryptSecrets rewrites the values tagged secret of the config files with
+// fn.
+func cryptSecrets(verb string, fn func(value string) (string, bool, error)) error {
+	keys := unified.SecretKeys()
+	return rewriteFiles(func(file string, root *yaml.Node) (bool, error) {
+		if misc.IsSOPS(root) {
+			return false, fmt.Errorf("%s is encrypted by SOPS, edit it with sops", file)
+		}
+		count, err := misc.MapSecrets(root, file, keys, fn)
+		if err != nil {
+			return false, err
+		}
+		fmt.Printf("%s: %d secrets %s\n", file, count, verb)
+		return count > 0, nil
+	})
+}
+
+// isSecretRef reports whether value references a secret stored elsewhere,
+// see misc.ResolveSecrets.
+func isSecretRef(value string) bool {
+	scheme, _, ok := strings.Cut(value, "://")
+	return ok && (scheme == "env" || scheme == "file" || scheme == "secret")
+}
+
+// rewriteFiles calls fn with the top level map of every config file, and
+// writes the file back in its format if fn changed it.
+func rewriteFiles(fn func(file string, root *yaml.Node) (bool, error)) error {
+	files, err := misc.ConfigFiles(configPath())
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		file, format := f.Path, f.Format
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		node, err := misc.ParseConfig(data, format)
+		if err != nil {
+			return fmt.Errorf("unable to unmarshall config %s %s: %w", format, file, err)
+		}
+		if len(node.Content) == 0 {
+			continue
+		}
+
+		changed, err := fn(file, node.Content[0])
+		if err != nil {
+			return err
+		}
+		if !changed {
+			continue
+		}
+		out, err := misc.EncodeConfig(node, format)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(file, out, info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// drift collects the announcements of the running services on the NATS
+// server of the config and reports the ones that disagree with each other
+// or run another config than the config files.
+func drift() error {
+	selected, err := selected()
+	if err != nil {
+		return err
+	}
+
+	local := map[string]string{}
+	host := ""
+	for _, svc := range selected {
+		cfg, err := load(svc)
+		if err != nil {
+			return fmt.Errorf("%s: %w", svc.Name, err)
+		}
+		local[svc.Name] = misc.Fingerprint(cfg)
+		host = cfg.(misc.Validatable).NATSConfig().Host
+	}
+
+	nc, err := nats.Connect(host)
+	if err != nil {
+		return fmt.Errorf("unable to connect to NATS server: %w", err)
+	}
+	defer nc.Close()
+
+	anns, err := misc.Collect(nc, *timeoutFlag)
+	if err != nil {
+		return err
+	}
+	if len(anns) == 0 {
+		return errors.New("no running service answered")
+	}
+
+	for _, a := range anns {
+		fmt.Printf("%s@%s %s config %s\n", a.Service, a.Host, a.Version, misc.ShortFingerprint(a.Fingerprint))
+	}
+	problems := misc.Drift(anns)
+	for _, a := range anns {
+		if f, ok := local[a.Service]; ok && f != a.Fingerprint {
+			problems = append(problems, fmt.Sprintf("%s@%s runs config %s, the config files have %s", a.Service, a.Host, misc.ShortFingerprint(a.Fingerprint), misc.ShortFingerprint(f)))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	for _, p := range problems {
+		fmt.Fprintln(os.Stderr, p)
+	}
+	return errors.New("config drift found")
+}
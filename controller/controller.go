@@ -1,46 +1,52 @@
 package controller
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
-	"os"
+	"time"
 
 	"github.com/DggHQ/dggarchiver-config/misc"
-	"github.com/containrrr/shoutrrr"
 	docker "github.com/docker/docker/client"
-	"github.com/joho/godotenv"
-	"gopkg.in/yaml.v2"
 	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
 
+var ErrTooManyBackends = errors.New("too many orchestration backends enabled")
+
 type DockerConfig struct {
-	Enabled    bool   `yaml:"enabled"`
-	AutoRemove bool   `yaml:"autoremove"`
-	Network    string `yaml:"network"`
+	Enabled    bool   `yaml:"enabled" desc:"start workers as Docker containers"`
+	AutoRemove bool   `yaml:"autoremove" desc:"remove worker containers once they exit"`
+	Network    string `yaml:"network" validate:"required" desc:"Docker network workers are attached to"`
 	Mount      struct {
-		Type   string `yaml:"type"`
-		Source string `yaml:"source"`
-	} `yaml:"mount"`
-	DockerSocket *docker.Client
+		Type   string `yaml:"type" validate:"required,oneof=volume bind" desc:"type of the mount shared with workers"`
+		Source string `yaml:"source" validate:"required" desc:"volume name or host path mounted into workers"`
+	} `yaml:"mount" desc:"storage mounted into workers"`
+	Timeout      time.Duration  `yaml:"timeout" default:"10s" desc:"time to wait for the Docker daemon when connecting"`
+	DockerSocket *docker.Client `yaml:"-"`
 }
 
 type K8sConfig struct {
-	Enabled           bool   `yaml:"enabled"`
-	Namespace         string `yaml:"namespace"`
-	CPULimitConfig    string `yaml:"cpu_limit"`
-	MemoryLimitConfig string `yaml:"memory_limit"`
-	K8sClientSet      *kubernetes.Clientset
-	CPUQuantity       resource.Quantity
-	MemoryQuantity    resource.Quantity
+	Enabled           bool                  `yaml:"enabled" desc:"start workers as K8s pods"`
+	Namespace         string                `yaml:"namespace" validate:"required" desc:"namespace worker pods are created in"`
+	CPULimitConfig    string                `yaml:"cpu_limit" validate:"required" desc:"CPU limit of worker pods, as a K8s quantity"`
+	MemoryLimitConfig string                `yaml:"memory_limit" validate:"required" desc:"memory limit of worker pods, as a K8s quantity"`
+	Timeout           time.Duration         `yaml:"timeout" default:"10s" desc:"time to wait for the K8s API when connecting"`
+	K8sClientSet      *kubernetes.Clientset `yaml:"-"`
+	CPUQuantity       resource.Quantity     `yaml:"-"`
+	MemoryQuantity    resource.Quantity     `yaml:"-"`
 }
 
 type Controller struct {
-	Verbose       bool
-	WorkerImage   string             `yaml:"worker_image"`
-	Docker        DockerConfig       `yaml:"docker"`
-	K8s           K8sConfig          `yaml:"k8s"`
-	Notifications misc.Notifications `yaml:"notifications"`
+	Verbose           bool               `desc:"enable debug logging"`
+	WorkerImage       string             `yaml:"worker_image" validate:"required" desc:"container image of the workers that download streams"`
+	WorkerDownloaders []string           `yaml:"worker_downloaders" default:"yt-dlp,yt-dlp/piped,ytarchive,N_m3u8DL-RE" desc:"downloaders the worker image supports"`
+	Docker            DockerConfig       `yaml:"docker" desc:"Docker orchestration backend"`
+	K8s               K8sConfig          `yaml:"k8s" desc:"K8s orchestration backend"`
+	Notifications     misc.Notifications `yaml:"notifications" desc:"notifications sent by the controller"`
+	ShutdownGrace     time.Duration      `yaml:"shutdown_grace" default:"30s" desc:"time given to the service to shut down on SIGINT or SIGTERM"`
 }
 
 type Config struct {
@@ -48,145 +54,158 @@ type Config struct {
 	NATS        misc.NATSConfig `yaml:"nats"`
 }
 
+// LogValue implements slog.LogValuer, masking passwords, tokens and the
+// credentials in service URLs.
+func (cfg Config) LogValue() slog.Value {
+	return misc.RedactedValue(cfg)
+}
+
+func (cfg Config) String() string {
+	return misc.RedactedString(cfg)
+}
+
+// New loads the controller config, logging the error and exiting on failure.
 func New() *Config {
-	var (
-		err error
-		cfg = Config{}
-		lvl slog.LevelVar
-	)
+	var lvl slog.LevelVar
 
 	misc.SetupSlog(&lvl)
 
-	_ = godotenv.Load()
-
-	configFile := os.Getenv("CONFIG")
-	if configFile == "" {
-		configFile = "config.yaml"
-	}
-	configBytes, err := os.ReadFile(configFile)
+	cfg, err := Load(misc.WithLevel(&lvl))
 	if err != nil {
-		slog.Error("unable to load config", slog.Any("err", err))
-		os.Exit(1)
+		misc.LogFatal(err)
 	}
 
-	err = yaml.Unmarshal(configBytes, &cfg)
+	return cfg
+}
+
+// Load reads and validates the controller config and connects to the
+// configured services, see misc.Load.
+func Load(opts ...misc.Option) (*Config, error) {
+	return misc.Load(&Config{}, opts...)
+}
+
+// Watch returns a watcher that reloads cfg whenever one of its config files
+// changes or SIGHUP is received, see misc.Watch.
+func Watch(cfg *Config, opts ...misc.Option) *misc.Watcher[Config] {
+	return misc.Watch(cfg, opts...)
+}
+
+// Validate implements misc.Validatable.
+func (cfg *Config) Validate(v *misc.Validator) {
+	cfg.Controller.validate(v)
+}
+
+// Connect implements misc.Validatable.
+func (cfg *Config) Connect(ctx context.Context) error {
+	return cfg.Controller.connect(ctx)
+}
+
+// Disconnect implements misc.Validatable, it closes the Docker client.
+func (cfg *Config) Disconnect() error {
+	if cfg.Controller == nil || cfg.Docker.DockerSocket == nil {
+		return nil
+	}
+	err := cfg.Docker.DockerSocket.Close()
+	cfg.Docker.DockerSocket = nil
 	if err != nil {
-		slog.Error("unable to unmarshall config yaml", slog.Any("err", err))
-		os.Exit(1)
+		return fmt.Errorf("unable to close the docker client: %w", err)
 	}
+	return nil
+}
 
-	if cfg.Controller.Verbose {
-		lvl.Set(slog.LevelDebug)
+// Close drains the NATS connection, then closes the Docker client.
+func (cfg *Config) Close(ctx context.Context) error {
+	var errs []error
+	if err := cfg.NATS.Close(ctx); err != nil {
+		errs = append(errs, err)
 	}
+	if err := cfg.Disconnect(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
 
-	cfg.Controller.initialize()
+// NATSConfig implements misc.Validatable.
+func (cfg *Config) NATSConfig() *misc.NATSConfig {
+	return &cfg.NATS
+}
 
-	// NATS Host Name or IP
-	if cfg.NATS.Host == "" {
-		slog.Error("config variable not set", slog.String("var", "nats:host"))
-		os.Exit(1)
-	}
-	// NATS Topic Name
-	if cfg.NATS.Topic == "" {
-		slog.Error("config variable not set", slog.String("var", "nats:topic"))
-		os.Exit(1)
-	}
-	cfg.NATS.Load()
+// ServiceName implements misc.Validatable.
+func (cfg *Config) ServiceName() string {
+	return "controller"
+}
 
-	return &cfg
+// IsVerbose implements misc.Validatable.
+func (cfg *Config) IsVerbose() bool {
+	return cfg.Controller.Verbose
 }
 
-func (controller *Controller) loadDocker() {
+func (controller *Controller) loadDocker(ctx context.Context) error {
 	var err error
 
 	controller.Docker.DockerSocket, err = docker.NewClientWithOpts(docker.FromEnv)
 	if err != nil {
-		slog.Error("unable to connect to the docker socket", slog.Any("err", err))
-		os.Exit(1)
+		return fmt.Errorf("unable to connect to the docker socket: %w", err)
 	}
-}
-
-func (controller *Controller) loadK8sConfig() {
-	cpuLimit, err := resource.ParseQuantity(controller.K8s.CPULimitConfig)
-	if err != nil {
-		slog.Error("unable to parse k8s cpu limit", slog.Any("err", err))
-		os.Exit(1)
-	}
-	controller.K8s.CPUQuantity = cpuLimit
-
-	memoryLimit, err := resource.ParseQuantity(controller.K8s.MemoryLimitConfig)
-	if err != nil {
-		slog.Error("unable to parse k8s memory limit", slog.Any("err", err))
-		os.Exit(1)
+	if _, err := controller.Docker.DockerSocket.Ping(ctx); err != nil {
+		return fmt.Errorf("unable to reach the docker daemon: %w", err)
 	}
-	controller.K8s.MemoryQuantity = memoryLimit
+	return nil
+}
 
+func (controller *Controller) loadK8sConfig(ctx context.Context) error {
 	clusterConfig, err := rest.InClusterConfig()
 	if err != nil {
-		slog.Error("unable to get k8s cluster config", slog.Any("err", err))
-		os.Exit(1)
+		return fmt.Errorf("unable to get k8s cluster config: %w", err)
 	}
 
 	clientSet, err := kubernetes.NewForConfig(clusterConfig)
 	if err != nil {
-		slog.Error("unable to create k8s client set", slog.Any("err", err))
-		os.Exit(1)
+		return fmt.Errorf("unable to create k8s client set: %w", err)
+	}
+	if err := clientSet.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Error(); err != nil {
+		return fmt.Errorf("unable to reach the k8s api: %w", err)
 	}
 	controller.K8s.K8sClientSet = clientSet
+	return nil
 }
 
-func (controller *Controller) initialize() {
+func (controller *Controller) validate(v *misc.Validator) {
 	// Docker and K8s
-	if controller.WorkerImage == "" {
-		slog.Error("config variable not set", slog.String("var", "controller:worker_image"))
-		os.Exit(1)
-	}
-
 	if controller.Docker.Enabled && controller.K8s.Enabled {
-		slog.Error("too many orchestration backends enabled")
-		os.Exit(1)
+		v.Add(controller, ErrTooManyBackends)
 	}
 
-	switch {
-	case controller.Docker.Enabled:
-		if controller.Docker.Network == "" {
-			slog.Error("config variable not set", slog.String("var", "controller:docker:network"))
-			os.Exit(1)
+	// Missing limits are reported by their required rule
+	if controller.K8s.Enabled {
+		if controller.K8s.CPULimitConfig != "" {
+			cpuLimit, err := resource.ParseQuantity(controller.K8s.CPULimitConfig)
+			if err != nil {
+				v.Add(&controller.K8s.CPULimitConfig, fmt.Errorf("unable to parse k8s cpu limit: %w", err))
+			}
+			controller.K8s.CPUQuantity = cpuLimit
 		}
-		switch controller.Docker.Mount.Type {
-		case "volume", "bind":
-		default:
-			slog.Error("invalid config variable", slog.String("var", "controller:docker:mount:type"))
-			os.Exit(1)
+		if controller.K8s.MemoryLimitConfig != "" {
+			memoryLimit, err := resource.ParseQuantity(controller.K8s.MemoryLimitConfig)
+			if err != nil {
+				v.Add(&controller.K8s.MemoryLimitConfig, fmt.Errorf("unable to parse k8s memory limit: %w", err))
+			}
+			controller.K8s.MemoryQuantity = memoryLimit
 		}
-		if controller.Docker.Mount.Source == "" {
-			slog.Error("config variable not set", slog.String("var", "controller:docker:mount:source"))
-			os.Exit(1)
-		}
-		controller.loadDocker()
-	case controller.K8s.Enabled:
-		if controller.K8s.Namespace == "" {
-			slog.Error("config variable not set", slog.String("var", "controller:k8s:namespace"))
-			os.Exit(1)
-		}
-		if controller.K8s.CPULimitConfig == "" {
-			slog.Error("config variable not set", slog.String("var", "controller:k8s:cpu_limit"))
-			os.Exit(1)
-		}
-		if controller.K8s.MemoryLimitConfig == "" {
-			slog.Error("config variable not set", slog.String("var", "controller:k8s:memory_limit"))
-			os.Exit(1)
-		}
-		controller.loadK8sConfig()
 	}
 
 	// Notifications
-	if controller.Notifications.Enabled() {
-		var err error
-		controller.Notifications.Sender, err = shoutrrr.CreateSender(controller.Notifications.Services...)
-		if err != nil {
-			slog.Error("unable to create notification sender", slog.Any("err", err))
-			os.Exit(1)
-		}
+	if err := controller.Notifications.Load(); err != nil {
+		v.Add(&controller.Notifications.Services, err)
+	}
+}
+
+func (controller *Controller) connect(ctx context.Context) error {
+	switch {
+	case controller.Docker.Enabled:
+		return misc.ConnectDependency(ctx, "docker", controller.Docker.Timeout, controller.loadDocker)
+	case controller.K8s.Enabled:
+		return misc.ConnectDependency(ctx, "k8s", controller.K8s.Timeout, controller.loadK8sConfig)
 	}
+	return nil
 }
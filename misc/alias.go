@@ -0,0 +1,112 @@
+package misc
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Aliases returns the deprecated keys still accepted for field, listed in
+// its comma separated alias tag.
+func Aliases(field reflect.StructField) []string {
+	tag := field.Tag.Get("alias")
+	if tag == "" {
+		return nil
+	}
+	return strings.Split(tag, ",")
+}
+
+// applyAliases renames the deprecated keys of n, decoded into t, to the
+// keys of their fields and records them in d.Deprecations. Setting both a
+// key and its alias is only accepted if they have the same value.
+func (d *Document) applyAliases(n *yaml.Node, t reflect.Type, path []string, verr *ValidationError) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			name, ok := FieldName(sf)
+			if !ok {
+				continue
+			}
+			keyPath := append(path[:len(path):len(path)], name)
+			for _, alias := range Aliases(sf) {
+				d.applyAlias(n, alias, name, path, verr)
+			}
+			if value := valueOf(n, name); value != nil {
+				d.applyAliases(value, sf.Type, keyPath, verr)
+			}
+		}
+	case reflect.Map:
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			d.applyAliases(n.Content[i+1], t.Elem(), append(path[:len(path):len(path)], n.Content[i].Value), verr)
+		}
+	case reflect.Slice:
+		if n.Kind != yaml.SequenceNode {
+			return
+		}
+		for _, item := range n.Content {
+			d.applyAliases(item, t.Elem(), path, verr)
+		}
+	}
+}
+
+func (d *Document) applyAlias(n *yaml.Node, alias, name string, path []string, verr *ValidationError) {
+	i := keyIndex(n, alias)
+	if i < 0 {
+		return
+	}
+	key := n.Content[i]
+	aliasKey := strings.Join(append(path[:len(path):len(path)], alias), ".")
+	newKey := strings.Join(append(path[:len(path):len(path)], name), ".")
+	pos := Position{File: d.files[key], Line: key.Line, Column: key.Column}
+
+	if j := keyIndex(n, name); j >= 0 {
+		if !equalNodes(n.Content[i+1], n.Content[j+1]) {
+			verr.Errors = append(verr.Errors, &FieldError{
+				Key:      newKey,
+				Err:      fmt.Errorf("%w: conflicts with its deprecated alias %s", ErrInvalid, aliasKey),
+				Position: pos,
+			})
+			return
+		}
+		n.Content = append(n.Content[:i], n.Content[i+2:]...)
+	} else {
+		key.Value = name
+	}
+
+	d.Deprecations = append(d.Deprecations, Deprecation{
+		Position: pos,
+		Key:      aliasKey,
+		Message:  "renamed to " + newKey,
+	})
+}
+
+func equalNodes(a, b *yaml.Node) bool {
+	for a.Kind == yaml.AliasNode {
+		a = a.Alias
+	}
+	for b.Kind == yaml.AliasNode {
+		b = b.Alias
+	}
+	if a.Kind != b.Kind || a.Value != b.Value || len(a.Content) != len(b.Content) {
+		return false
+	}
+	for i := range a.Content {
+		if !equalNodes(a.Content[i], b.Content[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,166 @@
+package misc
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type aliasPlatform struct {
+	RefreshTime int `yaml:"refresh_time" alias:"refresh_interval,refresh"`
+}
+
+type aliasConfig struct {
+	Notifier *struct {
+		Platforms struct {
+			Kick aliasPlatform `yaml:"kick"`
+		} `alias:"platform"`
+	} `yaml:"notifier"`
+}
+
+func TestAliases(t *testing.T) {
+	tests := []struct {
+		name       string
+		yaml       string
+		want       int
+		deprecated []string
+	}{
+		{
+			name: "new key",
+			yaml: "notifier:\n  platforms:\n    kick:\n      refresh_time: 5\n",
+			want: 5,
+		},
+		{
+			name:       "alias",
+			yaml:       "notifier:\n  platforms:\n    kick:\n      refresh_interval: 5\n",
+			want:       5,
+			deprecated: []string{"notifier.platforms.kick.refresh_interval"},
+		},
+		{
+			name:       "second alias",
+			yaml:       "notifier:\n  platforms:\n    kick:\n      refresh: 5\n",
+			want:       5,
+			deprecated: []string{"notifier.platforms.kick.refresh"},
+		},
+		{
+			name:       "aliased section",
+			yaml:       "notifier:\n  platform:\n    kick:\n      refresh_interval: 5\n",
+			want:       5,
+			deprecated: []string{"notifier.platform", "notifier.platforms.kick.refresh_interval"},
+		},
+		{
+			name:       "both with the same value",
+			yaml:       "notifier:\n  platforms:\n    kick:\n      refresh_time: 5\n      refresh_interval: 5\n",
+			want:       5,
+			deprecated: []string{"notifier.platforms.kick.refresh_interval"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := readConfig(t, tt.yaml)
+			var cfg aliasConfig
+			if _, err := doc.Decode(&cfg); err != nil {
+				t.Fatal(err)
+			}
+			if got := cfg.Notifier.Platforms.Kick.RefreshTime; got != tt.want {
+				t.Errorf("refresh_time = %d, want %d", got, tt.want)
+			}
+
+			var deprecated []string
+			for _, d := range doc.Deprecations {
+				deprecated = append(deprecated, d.Key)
+				if d.Line == 0 || !strings.HasPrefix(d.Message, "renamed to ") {
+					t.Errorf("deprecation %s lacks its position or new key", d)
+				}
+			}
+			if strings.Join(deprecated, ",") != strings.Join(tt.deprecated, ",") {
+				t.Errorf("deprecated %v, want %v", deprecated, tt.deprecated)
+			}
+		})
+	}
+}
+
+func TestAliasConflict(t *testing.T) {
+	doc := readConfig(t, "notifier:\n  platforms:\n    kick:\n      refresh_time: 5\n      refresh_interval: 10\n")
+	_, err := doc.Decode(&aliasConfig{})
+
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 {
+		t.Fatalf("Decode() = %v, want a single error", err)
+	}
+	fe := verr.Errors[0]
+	if fe.Key != "notifier.platforms.kick.refresh_time" || fe.Line != 5 || !errors.Is(fe, ErrInvalid) {
+		t.Errorf("Decode() = %v, want a conflict on refresh_time at line 5", fe)
+	}
+}
+
+func TestAliasesInOverlays(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    string
+		overlay string
+		profile string
+		want    int
+	}{
+		{
+			name:    "alias in base",
+			base:    "notifier:\n  platforms:\n    kick:\n      refresh_interval: 5\n",
+			overlay: "notifier:\n  platforms:\n    kick:\n      refresh_time: 10\n",
+			want:    10,
+		},
+		{
+			name:    "alias in overlay",
+			base:    "notifier:\n  platforms:\n    kick:\n      refresh_time: 5\n",
+			overlay: "notifier:\n  platforms:\n    kick:\n      refresh_interval: 10\n",
+			want:    10,
+		},
+		{
+			name:    "alias in profile",
+			base:    "notifier:\n  platforms:\n    kick:\n      refresh_time: 5\n",
+			overlay: "profiles:\n  dev:\n    notifier:\n      platforms:\n        kick:\n          refresh: 10\n",
+			profile: "dev",
+			want:    10,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := writeConfig(t, dir, "config.yaml", tt.base) + string(os.PathListSeparator) + writeConfig(t, dir, "overlay.yaml", tt.overlay)
+			doc, err := readDocument(path, false, reflect.TypeOf(&aliasConfig{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := doc.ApplyProfile(tt.profile); err != nil {
+				t.Fatal(err)
+			}
+			var cfg aliasConfig
+			if _, err := doc.Decode(&cfg); err != nil {
+				t.Fatal(err)
+			}
+			if got := cfg.Notifier.Platforms.Kick.RefreshTime; got != tt.want {
+				t.Errorf("refresh_time = %d, want %d", got, tt.want)
+			}
+			if len(doc.Deprecations) != 1 {
+				t.Errorf("deprecations = %v, want the alias", doc.Deprecations)
+			}
+		})
+	}
+}
+
+func TestAliasConflictInOneFile(t *testing.T) {
+	path := writeConfig(t, t.TempDir(), "config.yaml", "notifier:\n  platforms:\n    kick:\n      refresh_time: 5\n      refresh_interval: 10\n")
+	_, err := readDocument(path, false, reflect.TypeOf(&aliasConfig{}))
+
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 {
+		t.Fatalf("readDocument() = %v, want a single error", err)
+	}
+	if fe := verr.Errors[0]; fe.File != filepath.Clean(path) || fe.Line != 5 || !errors.Is(fe, ErrInvalid) {
+		t.Errorf("readDocument() = %v, want a conflict in %s at line 5", fe, path)
+	}
+}
@@ -0,0 +1,213 @@
+package misc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"gopkg.in/yaml.v3"
+)
+
+// Subjects the services announce their config on. They don't depend on
+// nats.topic, so that services disagreeing on it still see each other.
+const (
+	AnnounceSubject = "dggarchiver.config.announce"
+	// DiscoverSubject is requested to collect the announcements of the
+	// running services.
+	DiscoverSubject = "dggarchiver.config.discover"
+)
+
+// SharedKeys lists the settings every service must agree on. The other
+// nats settings, e.g. its timeout or the config bucket, may differ between
+// services.
+var SharedKeys = []string{"nats.topic"}
+
+// Announcement describes the config a service runs with.
+type Announcement struct {
+	Service       string `json:"service"`
+	Version       string `json:"version"`
+	Host          string `json:"host"`
+	ConfigVersion int    `json:"config_version"`
+	// Fingerprint is the SHA-256 of the redacted config, see Fingerprint.
+	Fingerprint string `json:"fingerprint"`
+	// Shared holds the values of SharedKeys, redacted.
+	Shared map[string]string `json:"shared"`
+	Time   time.Time         `json:"time"`
+}
+
+// Fingerprint returns the hex encoded SHA-256 of cfg with its secrets
+// masked. It is stable across restarts for an unchanged config.
+func Fingerprint(cfg any) string {
+	sum := sha256.Sum256([]byte(RedactedString(cfg)))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewAnnouncement returns the announcement of cfg, the config of service.
+func NewAnnouncement(service string, cfg any) *Announcement {
+	a := &Announcement{
+		Service:       service,
+		Version:       "(devel)",
+		ConfigVersion: ConfigVersion,
+		Fingerprint:   Fingerprint(cfg),
+		Shared:        map[string]string{},
+		Time:          time.Now().UTC(),
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		a.Version = info.Main.Version
+	}
+	a.Host, _ = os.Hostname()
+
+	root := RedactedNode(cfg)
+	for _, key := range SharedKeys {
+		n := root
+		for _, k := range strings.Split(key, ".") {
+			if n = valueOf(n, k); n == nil {
+				break
+			}
+		}
+		if n != nil && n.Kind == yaml.ScalarNode {
+			a.Shared[key] = n.Value
+		}
+	}
+	return a
+}
+
+// announcers holds the latest announcement of each service per connection,
+// which discovery requests are answered with.
+var announcers sync.Map
+
+type announcer struct {
+	mu     sync.Mutex
+	latest map[string]*Announcement
+	sub    *nats.Subscription
+}
+
+// Announce publishes a on AnnounceSubject and answers the requests on
+// DiscoverSubject with it until nc is closed or a newer announcement of
+// the same service is made.
+func Announce(nc *nats.Conn, a *Announcement) error {
+	v, _ := announcers.LoadOrStore(nc, &announcer{latest: map[string]*Announcement{}})
+	an := v.(*announcer)
+
+	an.mu.Lock()
+	defer an.mu.Unlock()
+	an.latest[a.Service] = a
+	if an.sub == nil {
+		sub, err := nc.Subscribe(DiscoverSubject, an.reply)
+		if err != nil {
+			return fmt.Errorf("unable to serve config discovery: %w", err)
+		}
+		an.sub = sub
+	}
+
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	if err := nc.Publish(AnnounceSubject, data); err != nil {
+		return fmt.Errorf("unable to announce config: %w", err)
+	}
+	return nil
+}
+
+// forgetAnnouncements stops answering discovery requests on nc.
+func forgetAnnouncements(nc *nats.Conn) {
+	announcers.Delete(nc)
+}
+
+func (an *announcer) reply(msg *nats.Msg) {
+	an.mu.Lock()
+	defer an.mu.Unlock()
+	for _, a := range an.latest {
+		if data, err := json.Marshal(a); err == nil {
+			_ = msg.Respond(data)
+		}
+	}
+}
+
+// Collect requests the announcements of the running services and returns
+// the ones received within timeout, sorted by service and host.
+func Collect(nc *nats.Conn, timeout time.Duration) ([]*Announcement, error) {
+	inbox := nc.NewRespInbox()
+	sub, err := nc.SubscribeSync(inbox)
+	if err != nil {
+		return nil, fmt.Errorf("unable to collect config announcements: %w", err)
+	}
+	defer func() { _ = sub.Unsubscribe() }()
+	if err := nc.PublishRequest(DiscoverSubject, inbox, nil); err != nil {
+		return nil, fmt.Errorf("unable to collect config announcements: %w", err)
+	}
+
+	var anns []*Announcement
+	deadline := time.Now().Add(timeout)
+	for {
+		msg, err := sub.NextMsg(time.Until(deadline))
+		if err == nats.ErrTimeout {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to collect config announcements: %w", err)
+		}
+		var a Announcement
+		if err := json.Unmarshal(msg.Data, &a); err != nil {
+			continue
+		}
+		anns = append(anns, &a)
+	}
+
+	sort.Slice(anns, func(i, j int) bool {
+		if anns[i].Service != anns[j].Service {
+			return anns[i].Service < anns[j].Service
+		}
+		return anns[i].Host < anns[j].Host
+	})
+	return anns, nil
+}
+
+// Drift returns the disagreements between announcements: values of
+// SharedKeys that differ, and replicas of a service running different
+// configs.
+func Drift(anns []*Announcement) []string {
+	var drift []string
+	for _, k := range SharedKeys {
+		values := map[string]bool{}
+		var desc []string
+		for _, a := range anns {
+			values[a.Shared[k]] = true
+			desc = append(desc, fmt.Sprintf("%s@%s=%q", a.Service, a.Host, a.Shared[k]))
+		}
+		if len(values) > 1 {
+			drift = append(drift, fmt.Sprintf("%s differs: %s", k, strings.Join(desc, ", ")))
+		}
+	}
+
+	fingerprints := map[string]map[string]bool{}
+	for _, a := range anns {
+		if fingerprints[a.Service] == nil {
+			fingerprints[a.Service] = map[string]bool{}
+		}
+		fingerprints[a.Service][a.Fingerprint] = true
+	}
+	for _, a := range anns {
+		if len(fingerprints[a.Service]) > 1 {
+			drift = append(drift, fmt.Sprintf("%s replicas run different configs: %s has %s", a.Service, a.Host, ShortFingerprint(a.Fingerprint)))
+		}
+	}
+	return drift
+}
+
+// ShortFingerprint returns the prefix of a fingerprint shown to users.
+func ShortFingerprint(f string) string {
+	if len(f) > 12 {
+		return f[:12]
+	}
+	return f
+}
@@ -0,0 +1,255 @@
+package misc
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var ErrUnknownKey = errors.New("unknown config key")
+
+// Sections lists the top level keys of config.yaml. A service only decodes
+// its own sections, the others are skipped instead of reported as unknown.
+var Sections = []string{"controller", "notifier", "uploader", "nats", "include", "version", "vars", "profiles"}
+
+// Positions maps the dotted keys of a config file to their location.
+type Positions map[string]Position
+
+// Find returns the position of key, or of its closest parent if key isn't
+// set in the file.
+func (p Positions) Find(key string) Position {
+	for {
+		if pos, ok := p[key]; ok {
+			return pos
+		}
+		i := strings.LastIndexByte(key, '.')
+		if i < 0 {
+			return p[""]
+		}
+		key = key[:i]
+	}
+}
+
+// Locate sets the position of the field errors in err that have none.
+func (p Positions) Locate(err error) error {
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		for _, fe := range verr.Errors {
+			if fe.Line == 0 {
+				fe.Position = p.Find(fe.Key)
+			}
+		}
+	}
+	return err
+}
+
+func (p Positions) index(n *yaml.Node, fileOf func(*yaml.Node) string, path []string) {
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i]
+			keyPath := append(path[:len(path):len(path)], key.Value)
+			p[strings.Join(keyPath, ".")] = Position{File: fileOf(key), Line: key.Line, Column: key.Column}
+			p.index(n.Content[i+1], fileOf, keyPath)
+		}
+	case yaml.SequenceNode:
+		for i, item := range n.Content {
+			itemPath := append(path[:len(path):len(path)], strconv.Itoa(i))
+			p[strings.Join(itemPath, ".")] = Position{File: fileOf(item), Line: item.Line, Column: item.Column}
+			p.index(item, fileOf, itemPath)
+		}
+	}
+}
+
+// Decode strictly decodes the document into cfg, a pointer to a service
+// config. Deprecated keys declared by alias tags that the sources didn't
+// rename when read, see Document.renameAliases, are renamed first and
+// added to d.Deprecations. Every key that doesn't match a field of cfg is
+// reported along with the closest known key. The returned Positions locate
+// the keys of the document in the files they were read from.
+func (d *Document) Decode(cfg any) (Positions, error) {
+	var verr ValidationError
+	d.applyAliases(d.Root, reflect.TypeOf(cfg), nil, &verr)
+	positions := d.Positions()
+	if err := verr.Err(); err != nil {
+		return positions, positions.Locate(err)
+	}
+
+	checkKeys(d.Root, reflect.TypeOf(cfg), nil, Sections, &verr)
+	if err := verr.Err(); err != nil {
+		return positions, positions.Locate(err)
+	}
+
+	if err := d.Root.Decode(cfg); err != nil {
+		var terr *yaml.TypeError
+		if errors.As(err, &terr) {
+			typeErrors(d.Root, reflect.TypeOf(cfg), nil, &verr)
+		}
+		if err := verr.Err(); err != nil {
+			return positions, positions.Locate(err)
+		}
+		return positions, fmt.Errorf("unable to unmarshall config yaml: %w", err)
+	}
+	return positions, nil
+}
+
+// typeErrors reports the values of n that can't be decoded into the field
+// of t they belong to, walking n the way checkKeys does.
+func typeErrors(n *yaml.Node, t reflect.Type, path []string, verr *ValidationError) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	if n.Tag == "!!null" || t.Kind() == reflect.Interface {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if n.Kind != yaml.MappingNode {
+			break
+		}
+		fields := map[string]reflect.Type{}
+		for i := 0; i < t.NumField(); i++ {
+			if name, ok := FieldName(t.Field(i)); ok {
+				fields[name] = t.Field(i).Type
+			}
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if ft, ok := fields[n.Content[i].Value]; ok {
+				typeErrors(n.Content[i+1], ft, append(path[:len(path):len(path)], n.Content[i].Value), verr)
+			}
+		}
+		return
+	case reflect.Map:
+		if n.Kind != yaml.MappingNode {
+			break
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			typeErrors(n.Content[i+1], t.Elem(), append(path[:len(path):len(path)], n.Content[i].Value), verr)
+		}
+		return
+	case reflect.Slice:
+		if n.Kind != yaml.SequenceNode {
+			break
+		}
+		for i, item := range n.Content {
+			typeErrors(item, t.Elem(), append(path[:len(path):len(path)], strconv.Itoa(i)), verr)
+		}
+		return
+	}
+
+	err := n.Decode(reflect.New(t).Interface())
+	var terr *yaml.TypeError
+	if !errors.As(err, &terr) {
+		return
+	}
+	for _, msg := range terr.Errors {
+		// Drop the "line N: " prefix, the field error has the position.
+		if _, rest, ok := strings.Cut(msg, ": "); ok && strings.HasPrefix(msg, "line ") {
+			msg = rest
+		}
+		verr.Add(Field{Path: path}.Key(), errors.New(msg))
+	}
+}
+
+// checkKeys reports the keys of n that t has no field for. allowed lists
+// extra keys that are accepted without being checked.
+func checkKeys(n *yaml.Node, t reflect.Type, path []string, allowed []string, verr *ValidationError) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+		fields := map[string]reflect.Type{}
+		names := make([]string, 0, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			if name, ok := FieldName(t.Field(i)); ok {
+				fields[name] = t.Field(i).Type
+				names = append(names, name)
+			}
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			keyPath := append(path[:len(path):len(path)], key.Value)
+			if ft, ok := fields[key.Value]; ok {
+				checkKeys(value, ft, keyPath, nil, verr)
+				continue
+			}
+			if key.Value == "<<" || slices.Contains(allowed, key.Value) {
+				continue
+			}
+			verr.Add(Field{Path: keyPath}.Key(), unknownKey(key, append(names, allowed...)))
+		}
+	case reflect.Map:
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			checkKeys(n.Content[i+1], t.Elem(), append(path[:len(path):len(path)], n.Content[i].Value), nil, verr)
+		}
+	case reflect.Slice:
+		if n.Kind != yaml.SequenceNode {
+			return
+		}
+		for _, item := range n.Content {
+			checkKeys(item, t.Elem(), path, nil, verr)
+		}
+	}
+}
+
+func unknownKey(key *yaml.Node, known []string) error {
+	if suggestion := Closest(key.Value, known); suggestion != "" {
+		return fmt.Errorf("%w, did you mean %q?", ErrUnknownKey, suggestion)
+	}
+	return ErrUnknownKey
+}
+
+// Closest returns the candidate nearest to s, or "" if none is close enough
+// to be a likely typo.
+func Closest(s string, candidates []string) string {
+	best, bestDist := "", len(s)/3+2
+	for _, c := range candidates {
+		if d := levenshtein(s, c); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = prev[j-1] + cost
+			if prev[j]+1 < curr[j] {
+				curr[j] = prev[j] + 1
+			}
+			if curr[j-1]+1 < curr[j] {
+				curr[j] = curr[j-1] + 1
+			}
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,237 @@
+package misc
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes a config file named name in dir and returns its path.
+func writeConfig(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(strings.TrimLeft(content, "\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readConfig reads content as a config.yaml file.
+func readConfig(t *testing.T, content string) *Document {
+	t.Helper()
+	doc, err := ReadDocument(writeConfig(t, t.TempDir(), "config.yaml", content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+type decodePlatform struct {
+	Enabled     bool     `yaml:"enabled"`
+	Channel     string   `yaml:"channel"`
+	Priority    int      `yaml:"restream_priority"`
+	RefreshTime int      `yaml:"refresh_time"`
+	Tags        []string `yaml:"tags"`
+}
+
+type decodeConfig struct {
+	Notifier *struct {
+		Verbose   bool
+		Platforms struct {
+			Kick    decodePlatform `yaml:"kick"`
+			YouTube decodePlatform `yaml:"youtube"`
+		}
+		Filters map[string]string `yaml:"filters"`
+	} `yaml:"notifier"`
+	NATS NATSConfig `yaml:"nats"`
+}
+
+func TestDecode(t *testing.T) {
+	doc := readConfig(t, `
+notifier:
+  verbose: yes
+  platforms:
+    kick:
+      enabled: yes
+      channel: destiny
+      refresh_time: 5
+      tags: [kick, live]
+  filters:
+    '(?i)rerun': skip
+controller:
+  worker_image: ignored
+nats:
+  host: localhost
+  topic: archiver
+`)
+
+	var cfg decodeConfig
+	if _, err := doc.Decode(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	kick := cfg.Notifier.Platforms.Kick
+	if !cfg.Notifier.Verbose || !kick.Enabled || kick.Channel != "destiny" || kick.RefreshTime != 5 || len(kick.Tags) != 2 {
+		t.Errorf("decoded %+v", cfg.Notifier)
+	}
+	if cfg.Notifier.Filters["(?i)rerun"] != "skip" || cfg.NATS.Topic != "archiver" {
+		t.Errorf("decoded filters %v and nats %+v", cfg.Notifier.Filters, cfg.NATS)
+	}
+}
+
+func TestDecodeUnknownKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		key  string
+		line int
+		want string
+	}{
+		{
+			name: "typo",
+			yaml: "notifier:\n  platforms:\n    kick:\n      restream_prority: 1\n",
+			key:  "notifier.platforms.kick.restream_prority",
+			line: 4,
+			want: `did you mean "restream_priority"?`,
+		},
+		{
+			name: "missing separator",
+			yaml: "notifier:\n  platforms:\n    youtube:\n      refreshtime: 5\n",
+			key:  "notifier.platforms.youtube.refreshtime",
+			line: 4,
+			want: `did you mean "refresh_time"?`,
+		},
+		{
+			name: "section",
+			yaml: "notifier:\n  platform:\n    kick: {}\n",
+			key:  "notifier.platform",
+			line: 2,
+			want: `did you mean "platforms"?`,
+		},
+		{
+			name: "top level",
+			yaml: "nats:\n  host: localhost\nnotifer:\n  verbose: yes\n",
+			key:  "notifer",
+			line: 3,
+			want: `did you mean "notifier"?`,
+		},
+		{
+			name: "no suggestion",
+			yaml: "nats:\n  host: localhost\n  password: hunter2\n",
+			key:  "nats.password",
+			line: 3,
+			want: ErrUnknownKey.Error(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := readConfig(t, tt.yaml)
+			_, err := doc.Decode(&decodeConfig{})
+
+			var verr *ValidationError
+			if !errors.As(err, &verr) || len(verr.Errors) != 1 {
+				t.Fatalf("Decode() = %v, want a single error", err)
+			}
+			fe := verr.Errors[0]
+			if fe.Key != tt.key || fe.Line != tt.line || !errors.Is(fe, ErrUnknownKey) || !strings.HasSuffix(fe.Err.Error(), tt.want) {
+				t.Errorf("Decode() = %v, want %s at line %d: %s", fe, tt.key, tt.line, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeReportsEveryUnknownKey(t *testing.T) {
+	doc := readConfig(t, `
+notifier:
+  platforms:
+    kick:
+      chanel: destiny
+    youtube:
+      refresh_tme: 5
+nats:
+  hots: localhost
+`)
+	_, err := doc.Decode(&decodeConfig{})
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 3 {
+		t.Fatalf("Decode() = %v, want 3 errors", err)
+	}
+}
+
+func TestDecodeTypeError(t *testing.T) {
+	doc := readConfig(t, `
+notifier:
+  platforms:
+    kick:
+      refresh_time: soon
+      tags: live
+    youtube:
+      tags: [a, [b]]
+nats:
+  host: localhost
+`)
+	_, err := doc.Decode(&decodeConfig{})
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Decode() = %v, want a *ValidationError", err)
+	}
+	want := []struct {
+		key  string
+		line int
+		err  string
+	}{
+		{"notifier.platforms.kick.refresh_time", 4, "cannot unmarshal !!str `soon` into int"},
+		{"notifier.platforms.kick.tags", 5, "cannot unmarshal !!str `live` into []string"},
+		{"notifier.platforms.youtube.tags.1", 7, "cannot unmarshal !!seq into string"},
+	}
+	if len(verr.Errors) != len(want) {
+		t.Fatalf("Decode() = %v, want %d errors", err, len(want))
+	}
+	for i, fe := range verr.Errors {
+		w := want[i]
+		if fe.Key != w.key || fe.Line != w.line || fe.File == "" || fe.Err.Error() != w.err {
+			t.Errorf("error %d = %v, want %s at line %d: %s", i, fe, w.key, w.line, w.err)
+		}
+	}
+}
+
+func TestClosest(t *testing.T) {
+	candidates := []string{"refresh_time", "restream_priority", "channel", "proxy_url", "worker_proxy_url"}
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"refreshtime", "refresh_time"},
+		{"restream_prority", "restream_priority"},
+		{"chanel", "channel"},
+		{"worker_proxy", "worker_proxy_url"},
+		{"quality", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := Closest(tt.s, candidates); got != tt.want {
+			t.Errorf("Closest(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestPositionsFind(t *testing.T) {
+	doc := readConfig(t, "nats:\n  host: localhost\n")
+	p := doc.Positions()
+
+	if pos := p.Find("nats.host"); pos.Line != 2 || pos.Column != 3 {
+		t.Errorf("Find(nats.host) = %s, want line 2 column 3", pos)
+	}
+	// Missing keys are located at their closest parent
+	if pos := p.Find("nats.topic"); pos.Line != 1 {
+		t.Errorf("Find(nats.topic) = %s, want line 1", pos)
+	}
+	if pos := p.Find("uploader.sqlite.uri"); pos.Line != 0 || !strings.HasSuffix(pos.File, "config.yaml") {
+		t.Errorf("Find(uploader.sqlite.uri) = %s, want the file", pos)
+	}
+}
@@ -0,0 +1,47 @@
+package misc
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// ApplyDefaults sets the empty fields of cfg, a pointer to a service
+// config, to the value of their default tag. The default of a map applies
+// to its empty values. As an explicit false or 0 can't be told apart from
+// an unset key, defaults are only meant for strings, lists and maps.
+func ApplyDefaults(cfg any) error {
+	var verr ValidationError
+	_ = WalkFields(cfg, func(f Field) error {
+		def, ok := f.Struct.Tag.Lookup("default")
+		if !ok {
+			return nil
+		}
+		if err := setDefault(f.Value, def); err != nil {
+			verr.Add(f.Key(), fmt.Errorf("invalid default %q: %w", def, err))
+		}
+		return nil
+	})
+	return verr.Err()
+}
+
+func setDefault(v reflect.Value, def string) error {
+	if v.Kind() != reflect.Map {
+		if !v.IsZero() {
+			return nil
+		}
+		return setString(v, def)
+	}
+
+	iter := v.MapRange()
+	for iter.Next() {
+		if !iter.Value().IsZero() {
+			continue
+		}
+		e := reflect.New(v.Type().Elem()).Elem()
+		if err := setString(e, def); err != nil {
+			return err
+		}
+		v.SetMapIndex(iter.Key(), e)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package misc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+)
+
+// DefaultTimeout bounds connecting to a dependency whose timeout isn't
+// configured, e.g. the K8s API the config is read from, unless
+// $CONFIG_TIMEOUT is set.
+const DefaultTimeout = 10 * time.Second
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// DependencyError reports an external service a service failed to connect
+// to when loading its config.
+type DependencyError struct {
+	// Dependency names the external service, e.g. nats or docker.
+	Dependency string
+	// Timeout is the timeout of the dependency if it expired, 0 otherwise.
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *DependencyError) Error() string {
+	if e.Timeout > 0 {
+		return fmt.Sprintf("%s: timed out after %s: %v", e.Dependency, e.Timeout, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Dependency, e.Err)
+}
+
+func (e *DependencyError) Unwrap() error {
+	return e.Err
+}
+
+// ConnectDependency calls connect with ctx bounded by timeout, and returns
+// its error as a *DependencyError naming dependency. A zero timeout only
+// keeps the deadline of ctx. Errors returned once the context is done wrap
+// its error, e.g. context.DeadlineExceeded.
+func ConnectDependency(ctx context.Context, dependency string, timeout time.Duration, connect func(ctx context.Context) error) error {
+	parent := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	err := connect(ctx)
+	if err == nil {
+		return nil
+	}
+	derr := &DependencyError{Dependency: dependency, Err: err}
+	if ctxErr := done(ctx); ctxErr != nil {
+		if !errors.Is(err, ctxErr) {
+			derr.Err = fmt.Errorf("%w: %w", ctxErr, err)
+		}
+		if done(parent) == nil {
+			derr.Timeout = timeout
+		}
+	}
+	return derr
+}
+
+// done returns the error of ctx once it is done or its deadline passed, as
+// clients bounded by the deadline can give up before ctx is done.
+func done(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+// configTimeout returns $CONFIG_TIMEOUT, or DefaultTimeout if unset.
+func configTimeout() (time.Duration, error) {
+	s := os.Getenv("CONFIG_TIMEOUT")
+	if s == "" {
+		return DefaultTimeout, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid CONFIG_TIMEOUT: %w", err)
+	}
+	return d, nil
+}
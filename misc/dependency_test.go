@@ -0,0 +1,96 @@
+package misc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnectDependency(t *testing.T) {
+	blocked := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		cancel  bool
+		connect func(ctx context.Context) error
+		timeOut time.Duration
+		is      error
+		want    string
+	}{
+		{
+			name:    "connected",
+			timeout: time.Second,
+			connect: func(context.Context) error { return nil },
+		},
+		{
+			name:    "failed",
+			timeout: time.Second,
+			connect: func(context.Context) error { return errors.New("connection refused") },
+			want:    "docker: connection refused",
+		},
+		{
+			name:    "blocked",
+			timeout: 50 * time.Millisecond,
+			connect: blocked,
+			timeOut: 50 * time.Millisecond,
+			is:      context.DeadlineExceeded,
+			want:    "docker: timed out after 50ms",
+		},
+		{
+			name:    "gave up at the deadline",
+			timeout: 50 * time.Millisecond,
+			connect: func(ctx context.Context) error {
+				deadline, _ := ctx.Deadline()
+				time.Sleep(time.Until(deadline))
+				return errors.New("i/o timeout")
+			},
+			timeOut: 50 * time.Millisecond,
+			is:      context.DeadlineExceeded,
+			want:    "docker: timed out after 50ms: context deadline exceeded: i/o timeout",
+		},
+		{
+			name:    "cancelled parent",
+			timeout: time.Hour,
+			cancel:  true,
+			connect: blocked,
+			is:      context.Canceled,
+			want:    "docker: context canceled",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				time.AfterFunc(10*time.Millisecond, cancel)
+			}
+
+			err := ConnectDependency(ctx, "docker", tt.timeout, tt.connect)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("ConnectDependency() = %v", err)
+				}
+				return
+			}
+			var derr *DependencyError
+			if !errors.As(err, &derr) {
+				t.Fatalf("ConnectDependency() = %v, want a *DependencyError", err)
+			}
+			if derr.Dependency != "docker" || derr.Timeout != tt.timeOut {
+				t.Errorf("ConnectDependency() = %+v, want docker with timeout %s", derr, tt.timeOut)
+			}
+			if tt.is != nil && !errors.Is(err, tt.is) {
+				t.Errorf("ConnectDependency() = %v, want %v", err, tt.is)
+			}
+			if !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("ConnectDependency() = %q, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package misc
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// KeyInfo documents a config key, as read from the tags of its field:
+//
+//	desc      description of the key
+//	default   value used when the key isn't set
+//	validate  rules the value must follow, see Rule
+//	secret    the value is masked when the config is printed
+//	alias     deprecated keys still accepted for the key
+type KeyInfo struct {
+	Key         string
+	Type        string
+	Default     string
+	Allowed     []string
+	Rules       string
+	Description string
+	Secret      bool
+	Aliases     []string
+}
+
+// Describe returns the documentation of every key of cfg, a pointer to a
+// service config, in declaration order.
+func Describe(cfg any) []KeyInfo {
+	var keys []KeyInfo
+	WalkTypes(reflect.TypeOf(cfg), func(path []string, field reflect.StructField) {
+		info := KeyInfo{
+			Key:         strings.Join(path, "."),
+			Type:        typeName(field.Type),
+			Default:     field.Tag.Get("default"),
+			Rules:       field.Tag.Get("validate"),
+			Description: field.Tag.Get("desc"),
+			Secret:      SecretTag(field) != "",
+			Aliases:     Aliases(field),
+		}
+		if oneof, ok := findRule(Rules(field), "oneof"); ok {
+			info.Allowed = strings.Fields(oneof.Param)
+		}
+		keys = append(keys, info)
+	})
+	return keys
+}
+
+// WalkTypes calls fn for every YAML field of t, a struct or pointer to a
+// struct type, and of the structs nested in it.
+func WalkTypes(t reflect.Type, fn func(path []string, field reflect.StructField)) {
+	walkTypes(t, nil, fn)
+}
+
+func walkTypes(t reflect.Type, path []string, fn func(path []string, field reflect.StructField)) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name, ok := FieldName(sf)
+		if !ok {
+			continue
+		}
+		fieldPath := append(path[:len(path):len(path)], name)
+		fn(fieldPath, sf)
+
+		ft := sf.Type
+		for ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct {
+			walkTypes(ft, fieldPath, fn)
+		}
+	}
+}
+
+func typeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeName(t.Elem())
+	case reflect.Struct:
+		return "section"
+	case reflect.Slice:
+		return "list of " + typeName(t.Elem())
+	case reflect.Map:
+		return fmt.Sprintf("map of %s to %s", typeName(t.Key()), typeName(t.Elem()))
+	}
+	if t == durationType {
+		return "duration"
+	}
+	return t.Kind().String()
+}
@@ -0,0 +1,346 @@
+package misc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"filippo.io/age"
+	"gopkg.in/yaml.v3"
+)
+
+// AppendTag marks a list in an overlay that is appended to the list it
+// overrides instead of replacing it:
+//
+//	services: !append
+//	  - discord://token@id
+const AppendTag = "!append"
+
+// Document is a config tree merged from one or more files.
+type Document struct {
+	Root *yaml.Node
+	// Files and Dirs list the files and conf.d directories that were read.
+	Files []string
+	Dirs  []string
+	// Remotes lists the sources read from outside of the file system.
+	Remotes []Remote
+	// Deprecations lists the keys rewritten while migrating old files, see
+	// Migrate.
+	Deprecations []Deprecation
+
+	files map[*yaml.Node]string
+	// verbatim holds the values taken as is, which aren't interpolated.
+	verbatim map[*yaml.Node]bool
+	// formats holds the format of each of Files.
+	formats map[string]string
+	// keepEncrypted leaves the encrypted values of the files as is.
+	keepEncrypted bool
+	identities    []age.Identity
+	// configType is the type of the config the document is decoded into,
+	// whose deprecated keys are renamed in each source before it is merged.
+	configType reflect.Type
+}
+
+// ReadDocument reads the config at path, which can be a file, a directory
+// whose .yaml, .yml, .json and .toml files are read in lexical order, or a
+// list of both separated by os.PathListSeparator. The format of the files
+// listed in path is given by ConfigFormat, and by FormatOf for the others
+// or if it is unset. A file can also list other files or directories to
+// read before it under the top level include key, relative to its own
+// directory.
+//
+// Each file is deep merged over the ones read before it: maps are merged,
+// while scalars and lists are replaced, unless the list is tagged !append.
+// Encrypted files and values are decrypted first, see AgeIdentities.
+func ReadDocument(path string) (*Document, error) {
+	return readDocument(path, false, nil)
+}
+
+// ConfigFile is a config file read by ReadDocument.
+type ConfigFile struct {
+	Path   string
+	Format string
+}
+
+// ConfigFiles returns the files ReadDocument reads for path, without
+// decrypting them.
+func ConfigFiles(path string) ([]ConfigFile, error) {
+	d, err := readDocument(path, true, nil)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]ConfigFile, 0, len(d.Files))
+	for _, f := range d.Files {
+		files = append(files, ConfigFile{Path: f, Format: d.formats[f]})
+	}
+	return files, nil
+}
+
+func readDocument(path string, keepEncrypted bool, configType reflect.Type) (*Document, error) {
+	format, err := ConfigFormat()
+	if err != nil {
+		return nil, err
+	}
+	d := &Document{
+		Root:          &yaml.Node{Kind: yaml.MappingNode},
+		files:         map[*yaml.Node]string{},
+		verbatim:      map[*yaml.Node]bool{},
+		formats:       map[string]string{},
+		keepEncrypted: keepEncrypted,
+		configType:    configType,
+	}
+	for _, p := range filepath.SplitList(path) {
+		if err := d.read(p, format, nil); err != nil {
+			return nil, err
+		}
+	}
+	d.clearAppendTags()
+	return d, nil
+}
+
+// Positions locates the keys of the document in the files they were read
+// from.
+func (d *Document) Positions() Positions {
+	p := Positions{}
+	if len(d.Files) > 0 {
+		p[""] = Position{File: d.Files[0]}
+	}
+	p.index(d.Root, func(n *yaml.Node) string { return d.files[n] }, nil)
+	return p
+}
+
+// read reads the file or directory at path. format overrides the format
+// of path if it is a file.
+func (d *Document) read(path, format string, includedBy []string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("unable to load config: %w", err)
+	}
+	if !info.IsDir() {
+		return d.readFile(path, format, includedBy)
+	}
+
+	d.Dirs = append(d.Dirs, path)
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return fmt.Errorf("unable to load config: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !isConfigFile(entry.Name()) {
+			continue
+		}
+		if err := d.readFile(filepath.Join(path, entry.Name()), "", includedBy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Document) readFile(path, format string, includedBy []string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("unable to load config: %w", err)
+	}
+	if slices.Contains(includedBy, abs) {
+		return fmt.Errorf("config include cycle: %s", strings.Join(append(includedBy, abs), " -> "))
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to load config: %w", err)
+	}
+	if format == "" {
+		format = FormatOf(path)
+	}
+	d.Files = append(d.Files, path)
+	d.formats[path] = format
+
+	doc, err := ParseConfig(data, format)
+	if err != nil {
+		return fmt.Errorf("unable to unmarshall config %s %s: %w", format, path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("unable to unmarshall config %s %s: top level must be a map", format, path)
+	}
+
+	if err := d.decrypt(root, path); err != nil {
+		return err
+	}
+	if err := d.migrate(root, path); err != nil {
+		return err
+	}
+	d.record(root, path)
+	if err := d.renameAliases(root); err != nil {
+		return err
+	}
+
+	// Included files are read first, so that the file including them
+	// overrides their keys
+	if include := removeKey(root, "include"); include != nil {
+		var paths []string
+		if include.Kind == yaml.ScalarNode {
+			paths = []string{include.Value}
+		} else if err := include.Decode(&paths); err != nil {
+			return fmt.Errorf("invalid include in %s: %w", path, err)
+		}
+		for _, p := range paths {
+			if !filepath.IsAbs(p) {
+				p = filepath.Join(filepath.Dir(path), p)
+			}
+			if err := d.read(p, "", append(includedBy, abs)); err != nil {
+				return err
+			}
+		}
+	}
+
+	mergeNodes(d.Root, root)
+	return nil
+}
+
+// merge parses data, read from source, and merges it over the document.
+// The format of data is given by the extension of name.
+func (d *Document) merge(data []byte, name, source string) error {
+	format := FormatOf(name)
+	doc, err := ParseConfig(data, format)
+	if err != nil {
+		return fmt.Errorf("unable to unmarshall config %s %s: %w", format, source, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("unable to unmarshall config %s %s: top level must be a map", format, source)
+	}
+
+	if err := d.decrypt(root, source); err != nil {
+		return err
+	}
+	if err := d.migrate(root, source); err != nil {
+		return err
+	}
+	d.record(root, source)
+	if err := d.renameAliases(root); err != nil {
+		return err
+	}
+	mergeNodes(d.Root, root)
+	d.clearAppendTags()
+	return nil
+}
+
+// migrate upgrades root, read from file, and records its deprecations.
+// Outdated files are only reported if a migration rewrote one of their
+// keys, as most overlays never set the keys that changed.
+func (d *Document) migrate(root *yaml.Node, file string) error {
+	// The values of SOPS files can only be migrated once decrypted
+	if d.keepEncrypted && IsSOPS(root) {
+		return nil
+	}
+	version, deprecations, err := Migrate(root, file)
+	if err != nil {
+		return err
+	}
+	if len(deprecations) > 0 {
+		d.Deprecations = append(d.Deprecations, Deprecation{
+			Position: Position{File: file, Line: root.Line, Column: root.Column},
+			Key:      "version",
+			Message:  fmt.Sprintf("config version %d is outdated, upgrade it with dggarchiver-config migrate", version),
+		})
+	}
+	d.Deprecations = append(d.Deprecations, deprecations...)
+	return nil
+}
+
+// renameAliases renames the deprecated keys of root, the top level map of
+// a single source, and of its profiles. Setting both a key and its alias is
+// thus only a conflict within one file, an overlay can set the key the base
+// file sets under its old name.
+func (d *Document) renameAliases(root *yaml.Node) error {
+	if d.configType == nil || (d.keepEncrypted && IsSOPS(root)) {
+		return nil
+	}
+	var verr ValidationError
+	d.applyAliases(root, d.configType, nil, &verr)
+	if profiles := valueOf(root, "profiles"); profiles != nil && profiles.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(profiles.Content); i += 2 {
+			d.applyAliases(profiles.Content[i+1], d.configType, []string{"profiles", profiles.Content[i].Value}, &verr)
+		}
+	}
+	return verr.Err()
+}
+
+// clearAppendTags removes the !append tags once every source is merged.
+// Profiles keep theirs until they are merged by ApplyProfile.
+func (d *Document) clearAppendTags() {
+	for i := 0; i+1 < len(d.Root.Content); i += 2 {
+		if d.Root.Content[i].Value != "profiles" {
+			clearTag(d.Root.Content[i+1], AppendTag)
+		}
+	}
+}
+
+func (d *Document) record(n *yaml.Node, file string) {
+	d.files[n] = file
+	for _, c := range n.Content {
+		d.record(c, file)
+	}
+}
+
+// mergeNodes deep merges the mapping src into dst.
+func mergeNodes(dst, src *yaml.Node) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+		j := keyIndex(dst, key.Value)
+		if j < 0 {
+			dst.Content = append(dst.Content, key, value)
+			continue
+		}
+
+		current := dst.Content[j+1]
+		switch {
+		case current.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode:
+			mergeNodes(current, value)
+		case current.Kind == yaml.SequenceNode && value.Kind == yaml.SequenceNode && value.Tag == AppendTag:
+			current.Content = append(current.Content, value.Content...)
+		default:
+			dst.Content[j], dst.Content[j+1] = key, value
+		}
+	}
+}
+
+// keyIndex returns the index of key in the mapping n, or -1.
+func keyIndex(n *yaml.Node, key string) int {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// removeKey removes key from the mapping n and returns its value.
+func removeKey(n *yaml.Node, key string) *yaml.Node {
+	i := keyIndex(n, key)
+	if i < 0 {
+		return nil
+	}
+	value := n.Content[i+1]
+	n.Content = append(n.Content[:i], n.Content[i+2:]...)
+	return value
+}
+
+func clearTag(n *yaml.Node, tag string) {
+	if n.Tag == tag {
+		n.Tag = ""
+	}
+	for _, c := range n.Content {
+		clearTag(c, tag)
+	}
+}
@@ -0,0 +1,203 @@
+package misc
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// decodeMap decodes the document into a map, without the version key set
+// when files are migrated.
+func decodeMap(t *testing.T, doc *Document) map[string]any {
+	t.Helper()
+	var m map[string]any
+	if err := doc.Root.Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	delete(m, "version")
+	return m
+}
+
+func TestMergeNodes(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    string
+		overlay string
+		want    string
+	}{
+		{
+			name:    "maps are merged",
+			base:    "nats:\n  host: localhost\n  topic: archiver\n",
+			overlay: "nats:\n  host: nats.prod\n",
+			want:    "nats:\n  host: nats.prod\n  topic: archiver\n",
+		},
+		{
+			name:    "new keys are added",
+			base:    "nats:\n  host: localhost\n",
+			overlay: "uploader:\n  verbose: yes\n",
+			want:    "nats:\n  host: localhost\nuploader:\n  verbose: yes\n",
+		},
+		{
+			name:    "lists are replaced",
+			base:    "services: [a, b]\n",
+			overlay: "services: [c]\n",
+			want:    "services: [c]\n",
+		},
+		{
+			name:    "lists tagged !append are appended",
+			base:    "services: [a, b]\n",
+			overlay: "services: !append [c]\n",
+			want:    "services: [a, b, c]\n",
+		},
+		{
+			name:    "scalars replace maps",
+			base:    "filters:\n  a: skip\n",
+			overlay: "filters: null\n",
+			want:    "filters: null\n",
+		},
+		{
+			name:    "maps replace scalars",
+			base:    "filters: null\n",
+			overlay: "filters:\n  a: skip\n",
+			want:    "filters:\n  a: skip\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var base, overlay, want yaml.Node
+			for n, s := range map[*yaml.Node]string{&base: tt.base, &overlay: tt.overlay, &want: tt.want} {
+				if err := yaml.Unmarshal([]byte(s), n); err != nil {
+					t.Fatal(err)
+				}
+			}
+			mergeNodes(base.Content[0], overlay.Content[0])
+
+			var got, wantValue any
+			if err := base.Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if err := want.Decode(&wantValue); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, wantValue) {
+				t.Errorf("merged %v, want %v", got, wantValue)
+			}
+		})
+	}
+}
+
+func TestReadDocumentLayers(t *testing.T) {
+	dir := t.TempDir()
+	base := writeConfig(t, dir, "base.yaml", `
+nats:
+  host: localhost
+  topic: archiver
+notifier:
+  notifications:
+    services: [discord://a@b]
+`)
+	prod := writeConfig(t, dir, "prod.yaml", `
+nats:
+  host: nats.prod
+notifier:
+  notifications:
+    services: !append [telegram://c@d]
+`)
+
+	doc, err := ReadDocument(base + string(os.PathListSeparator) + prod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"nats": map[string]any{"host": "nats.prod", "topic": "archiver"},
+		"notifier": map[string]any{"notifications": map[string]any{
+			"services": []any{"discord://a@b", "telegram://c@d"},
+		}},
+	}
+	if got := decodeMap(t, doc); !reflect.DeepEqual(got, want) {
+		t.Errorf("merged %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(doc.Files, []string{base, prod}) {
+		t.Errorf("files = %v", doc.Files)
+	}
+
+	// Keys are located in the file that set them last
+	positions := doc.Positions()
+	if pos := positions.Find("nats.host"); pos.File != prod || pos.Line != 2 {
+		t.Errorf("nats.host at %s, want %s:2", pos, prod)
+	}
+	if pos := positions.Find("nats.topic"); pos.File != base || pos.Line != 3 {
+		t.Errorf("nats.topic at %s, want %s:3", pos, base)
+	}
+}
+
+func TestReadDocumentDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeConfig(t, dir, "conf.d/10-base.yaml", "nats:\n  host: localhost\n  topic: archiver\n")
+	writeConfig(t, dir, "conf.d/20-nats.json", `{"nats": {"host": "nats.prod"}}`)
+	writeConfig(t, dir, "conf.d/README.md", "not a config file")
+
+	doc, err := ReadDocument(filepath.Join(dir, "conf.d"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"nats": map[string]any{"host": "nats.prod", "topic": "archiver"}}
+	if got := decodeMap(t, doc); !reflect.DeepEqual(got, want) {
+		t.Errorf("merged %v, want %v", got, want)
+	}
+	if len(doc.Files) != 2 || len(doc.Dirs) != 1 {
+		t.Errorf("files = %v, dirs = %v", doc.Files, doc.Dirs)
+	}
+}
+
+func TestReadDocumentInclude(t *testing.T) {
+	dir := t.TempDir()
+	writeConfig(t, dir, "shared/nats.yaml", "nats:\n  host: localhost\n  topic: archiver\n")
+	config := writeConfig(t, dir, "config.yaml", "include: shared/nats.yaml\nnats:\n  topic: staging\n")
+
+	doc, err := ReadDocument(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The including file overrides the included one
+	want := map[string]any{"nats": map[string]any{"host": "localhost", "topic": "staging"}}
+	if got := decodeMap(t, doc); !reflect.DeepEqual(got, want) {
+		t.Errorf("merged %v, want %v", got, want)
+	}
+}
+
+func TestReadDocumentIncludeCycle(t *testing.T) {
+	dir := t.TempDir()
+	writeConfig(t, dir, "a.yaml", "include: b.yaml\n")
+	writeConfig(t, dir, "b.yaml", "include: a.yaml\n")
+
+	_, err := ReadDocument(filepath.Join(dir, "a.yaml"))
+	if err == nil || !strings.Contains(err.Error(), "include cycle") {
+		t.Errorf("ReadDocument() = %v, want an include cycle error", err)
+	}
+}
+
+func TestReadDocumentErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"missing", filepath.Join(dir, "missing.yaml"), "unable to load config"},
+		{"invalid", writeConfig(t, dir, "invalid.yaml", "nats: [\n"), "unable to unmarshall config yaml"},
+		{"not a map", writeConfig(t, dir, "list.yaml", "- nats\n"), "top level must be a map"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReadDocument(tt.path); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ReadDocument() = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
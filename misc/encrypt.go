@@ -0,0 +1,246 @@
+package misc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"gopkg.in/yaml.v3"
+)
+
+// Values encrypted with age are written ENC[age,<base64 ciphertext>], see
+// EncryptValue.
+const (
+	encryptedPrefix = "ENC[age,"
+	encryptedSuffix = "]"
+)
+
+var ErrNoAgeKey = errors.New("no age key, set CONFIG_AGE_KEY or CONFIG_AGE_KEY_FILE")
+
+// AgeIdentities returns the age keys encrypted config values are decrypted
+// with: the keys in $CONFIG_AGE_KEY, or in the file $CONFIG_AGE_KEY_FILE.
+// $SOPS_AGE_KEY and $SOPS_AGE_KEY_FILE are used if neither is set.
+func AgeIdentities() ([]age.Identity, error) {
+	for _, prefix := range []string{"CONFIG", "SOPS"} {
+		if key := os.Getenv(prefix + "_AGE_KEY"); key != "" {
+			ids, err := age.ParseIdentities(strings.NewReader(key))
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s_AGE_KEY: %w", prefix, err)
+			}
+			return ids, nil
+		}
+		if file := os.Getenv(prefix + "_AGE_KEY_FILE"); file != "" {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read age key: %w", err)
+			}
+			ids, err := age.ParseIdentities(bytes.NewReader(data))
+			if err != nil {
+				return nil, fmt.Errorf("invalid age key %s: %w", file, err)
+			}
+			return ids, nil
+		}
+	}
+	return nil, ErrNoAgeKey
+}
+
+// AgeRecipients parses the age public keys values are encrypted to. It
+// defaults to the public keys of AgeIdentities.
+func AgeRecipients(keys []string) ([]age.Recipient, error) {
+	var recipients []age.Recipient
+	if len(keys) == 0 {
+		ids, err := AgeIdentities()
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			if x, ok := id.(*age.X25519Identity); ok {
+				recipients = append(recipients, x.Recipient())
+			}
+		}
+		return recipients, nil
+	}
+
+	for _, key := range keys {
+		r, err := age.ParseX25519Recipient(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid age recipient %s: %w", key, err)
+		}
+		recipients = append(recipients, r)
+	}
+	return recipients, nil
+}
+
+// IsEncrypted reports whether s is a value encrypted with EncryptValue.
+func IsEncrypted(s string) bool {
+	return strings.HasPrefix(s, encryptedPrefix) && strings.HasSuffix(s, encryptedSuffix)
+}
+
+// EncryptValue encrypts s to recipients and returns it as
+// ENC[age,<base64 ciphertext>].
+func EncryptValue(s string, recipients []age.Recipient) (string, error) {
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, recipients...)
+	if err != nil {
+		return "", fmt.Errorf("unable to encrypt value: %w", err)
+	}
+	if _, err := io.WriteString(w, s); err != nil {
+		return "", fmt.Errorf("unable to encrypt value: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("unable to encrypt value: %w", err)
+	}
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(buf.Bytes()) + encryptedSuffix, nil
+}
+
+// DecryptValue decrypts s, a value returned by EncryptValue.
+func DecryptValue(s string, identities []age.Identity) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(s, encryptedPrefix), encryptedSuffix))
+	if err != nil {
+		return "", fmt.Errorf("%w: encrypted value isn't base64: %s", ErrInvalid, err)
+	}
+	r, err := age.Decrypt(bytes.NewReader(data), identities...)
+	if err != nil {
+		return "", fmt.Errorf("unable to decrypt value: %w", err)
+	}
+	value, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("unable to decrypt value: %w", err)
+	}
+	return string(value), nil
+}
+
+// MapSecrets replaces the values at keys in root, the top level map of the
+// config file file, and in its profiles with fn(value). A key can hold a
+// string or a list of strings. fn returns false to keep a value as is.
+// MapSecrets returns the number of values replaced.
+func MapSecrets(root *yaml.Node, file string, keys []string, fn func(value string) (string, bool, error)) (int, error) {
+	roots := []*yaml.Node{root}
+	if profiles := valueOf(root, "profiles"); profiles != nil && profiles.Kind == yaml.MappingNode {
+		for i := 1; i < len(profiles.Content); i += 2 {
+			roots = append(roots, profiles.Content[i])
+		}
+	}
+
+	count := 0
+	var verr ValidationError
+	for _, r := range roots {
+		for _, key := range keys {
+			n := r
+			for _, name := range strings.Split(key, ".") {
+				if n = valueOf(n, name); n == nil {
+					break
+				}
+			}
+			if n == nil {
+				continue
+			}
+
+			values := []*yaml.Node{n}
+			if n.Kind == yaml.SequenceNode {
+				values = n.Content
+			}
+			for _, v := range values {
+				if v.Kind != yaml.ScalarNode || v.Value == "" {
+					continue
+				}
+				value, ok, err := fn(v.Value)
+				if err != nil {
+					verr.Errors = append(verr.Errors, &FieldError{
+						Key:      key,
+						Err:      err,
+						Position: Position{File: file, Line: v.Line, Column: v.Column},
+					})
+					continue
+				}
+				if ok {
+					v.Value, v.Tag, v.Style = value, "!!str", 0
+					count++
+				}
+			}
+		}
+	}
+	return count, verr.Err()
+}
+
+// decrypt decrypts root, read from file, in place: the whole file if it
+// was encrypted by SOPS, then its ENC[age,...] values. Decrypted values are
+// taken verbatim, a $ in a password isn't the start of a ${VAR} reference.
+// The age keys are read the first time they are needed.
+func (d *Document) decrypt(root *yaml.Node, file string) error {
+	if d.keepEncrypted {
+		return nil
+	}
+
+	type encrypted struct {
+		n    *yaml.Node
+		path []string
+	}
+	var values []encrypted
+	walkScalars(root, nil, func(n *yaml.Node, path []string) {
+		if IsEncrypted(n.Value) {
+			values = append(values, encrypted{n, path})
+		}
+	})
+	sops := IsSOPS(root)
+	if !sops && len(values) == 0 {
+		return nil
+	}
+
+	if d.identities == nil {
+		ids, err := AgeIdentities()
+		if err != nil {
+			return fmt.Errorf("unable to decrypt config %s: %w", file, err)
+		}
+		d.identities = ids
+	}
+	if sops {
+		decrypted, err := decryptSOPS(root, file, d.identities)
+		if err != nil {
+			return err
+		}
+		for _, n := range decrypted {
+			d.verbatim[n] = true
+		}
+	}
+
+	var verr ValidationError
+	for _, v := range values {
+		value, err := DecryptValue(v.n.Value, d.identities)
+		if err != nil {
+			verr.Errors = append(verr.Errors, &FieldError{
+				Key:      strings.Join(v.path, "."),
+				Err:      err,
+				Position: Position{File: file, Line: v.n.Line, Column: v.n.Column},
+			})
+			continue
+		}
+		// Let the value resolve to the type of what it holds, as if it
+		// was written as is, but don't interpolate it
+		v.n.Value, v.n.Tag = value, ""
+		d.verbatim[v.n] = true
+	}
+	return verr.Err()
+}
+
+// walkScalars calls fn for every scalar value of n along with the keys
+// leading to it. The items of a list have the path of the list.
+func walkScalars(n *yaml.Node, path []string, fn func(n *yaml.Node, path []string)) {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		fn(n, path)
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			walkScalars(n.Content[i+1], append(path[:len(path):len(path)], n.Content[i].Value), fn)
+		}
+	case yaml.SequenceNode:
+		for _, item := range n.Content {
+			walkScalars(item, path, fn)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package misc
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEncryptValue(t *testing.T) {
+	t.Setenv("CONFIG_AGE_KEY", testAgeKey)
+	recipients, err := AgeRecipients(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := EncryptValue("hunter2", recipients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(encrypted) {
+		t.Errorf("IsEncrypted(%s) = false", encrypted)
+	}
+	identities, err := AgeIdentities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, err := DecryptValue(encrypted, identities); err != nil || value != "hunter2" {
+		t.Errorf("DecryptValue() = %q, %v, want hunter2", value, err)
+	}
+	if _, err := DecryptValue("ENC[age,not base64]", identities); !errors.Is(err, ErrInvalid) {
+		t.Errorf("DecryptValue() = %v, want %v", err, ErrInvalid)
+	}
+}
+
+func TestDecryptedValuesAreNotInterpolated(t *testing.T) {
+	t.Setenv("CONFIG_AGE_KEY", testAgeKey)
+	recipients, err := AgeRecipients(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	password, err := EncryptValue("pa$$word${", recipients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retries, err := EncryptValue("3", recipients)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := readConfig(t, "uploader:\n  password: "+password+"\n  retries: "+retries+"\n  user: ${DGGARCHIVER_TEST_USER:-archiver}\n")
+	if err := doc.Interpolate(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"password": "pa$$word${", "retries": 3, "user": "archiver"}
+	got := decodeMap(t, doc)["uploader"].(map[string]any)
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %#v, want %#v", k, got[k], v)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package misc
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is prepended to the environment variables that override
+// config keys.
+const EnvPrefix = "DGGARCHIVER_"
+
+// EnvName returns the environment variable overriding the field at path,
+// e.g. DGGARCHIVER_UPLOADER_PLATFORMS_ODYSEE_PASSWORD.
+func EnvName(path []string) string {
+	return EnvPrefix + strings.ToUpper(strings.Join(path, "_"))
+}
+
+// ApplyEnv overrides the fields of cfg, a pointer to a service config, with
+// the environment variables named by EnvName. Lists and maps are given as a
+// YAML or JSON flow collection, e.g. ["a", "b"] or {"key": "value"}, so
+// their items may contain commas. A list can also be given one item per
+// variable with an index suffix, e.g. DGGARCHIVER_NOTIFIER_NOTIFICATIONS_SERVICES_0,
+// and a value that isn't a flow list is a list of one item.
+func ApplyEnv(cfg any) error {
+	var verr ValidationError
+	_ = WalkFields(cfg, func(f Field) error {
+		if f.Value.Kind() == reflect.Struct {
+			return nil
+		}
+		name := EnvName(f.Path)
+		if value, ok := os.LookupEnv(name); ok {
+			if err := setEnv(f.Value, value); err != nil {
+				verr.Add(f.Key(), fmt.Errorf("invalid value in %s: %w", name, err))
+			}
+			return nil
+		}
+		if f.Value.Kind() != reflect.Slice {
+			return nil
+		}
+		slice := reflect.MakeSlice(f.Value.Type(), 0, 0)
+		for i := 0; ; i++ {
+			indexed := name + "_" + strconv.Itoa(i)
+			value, ok := os.LookupEnv(indexed)
+			if !ok {
+				break
+			}
+			e := reflect.New(f.Value.Type().Elem()).Elem()
+			if err := setString(e, value); err != nil {
+				verr.Add(f.Key(), fmt.Errorf("invalid value in %s: %w", indexed, err))
+				return nil
+			}
+			slice = reflect.Append(slice, e)
+		}
+		if slice.Len() > 0 {
+			f.Value.Set(slice)
+		}
+		return nil
+	})
+	return verr.Err()
+}
+
+// setEnv sets v to the value of an environment variable. Unlike the
+// default tags handled by setString, lists and maps are flow collections.
+func setEnv(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+	default:
+		return setString(v, s)
+	}
+
+	trimmed := strings.TrimSpace(s)
+	switch {
+	case trimmed == "":
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	case v.Kind() == reflect.Slice && !strings.HasPrefix(trimmed, "["):
+		e := reflect.New(v.Type().Elem()).Elem()
+		if err := setString(e, s); err != nil {
+			return err
+		}
+		v.Set(reflect.Append(reflect.MakeSlice(v.Type(), 0, 1), e))
+		return nil
+	case v.Kind() == reflect.Map && !strings.HasPrefix(trimmed, "{"):
+		return fmt.Errorf("expected a map such as {\"key\": \"value\"}")
+	}
+
+	p := reflect.New(v.Type())
+	if err := yaml.Unmarshal([]byte(trimmed), p.Interface()); err != nil {
+		return err
+	}
+	v.Set(p.Elem())
+	return nil
+}
+
+func setString(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := parseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Type() == durationType {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return err
+			}
+			v.SetInt(int64(d))
+			break
+		}
+		i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Slice:
+		items := splitList(s)
+		slice := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setString(slice.Index(i), item); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	case reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		for _, item := range splitList(s) {
+			key, value, _ := strings.Cut(item, "=")
+			k := reflect.New(v.Type().Key()).Elem()
+			if err := setString(k, key); err != nil {
+				return err
+			}
+			e := reflect.New(v.Type().Elem()).Elem()
+			if err := setString(e, value); err != nil {
+				return err
+			}
+			m.SetMapIndex(k, e)
+		}
+		v.Set(m)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	items := strings.Split(s, ",")
+	for i := range items {
+		items[i] = strings.TrimSpace(items[i])
+	}
+	return items
+}
+
+// parseBool accepts the YAML 1.1 booleans used in config.yaml on top of
+// the ones strconv.ParseBool does.
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "yes", "y", "on":
+		return true, nil
+	case "no", "n", "off":
+		return false, nil
+	}
+	return strconv.ParseBool(s)
+}
@@ -0,0 +1,163 @@
+package misc
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type envSection struct {
+	Name     string            `yaml:"name"`
+	Enabled  bool              `yaml:"enabled"`
+	Count    int               `yaml:"count"`
+	Timeout  time.Duration     `yaml:"timeout"`
+	Services []string          `yaml:"services"`
+	Filters  map[string]string `yaml:"filters"`
+	Platform struct {
+		Password string `yaml:"password"`
+	} `yaml:"platform"`
+}
+
+type envConfig struct {
+	Service *envSection `yaml:"service"`
+	NATS    NATSConfig  `yaml:"nats"`
+}
+
+func newEnvConfig() *envConfig {
+	return &envConfig{Service: &envSection{}}
+}
+
+func TestEnvName(t *testing.T) {
+	tests := []struct {
+		path []string
+		want string
+	}{
+		{[]string{"nats", "host"}, "DGGARCHIVER_NATS_HOST"},
+		{[]string{"uploader", "platforms", "odysee", "password"}, "DGGARCHIVER_UPLOADER_PLATFORMS_ODYSEE_PASSWORD"},
+		{[]string{"notifier", "platforms", "kick", "refresh_time"}, "DGGARCHIVER_NOTIFIER_PLATFORMS_KICK_REFRESH_TIME"},
+	}
+	for _, tt := range tests {
+		if got := EnvName(tt.path); got != tt.want {
+			t.Errorf("EnvName(%v) = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		check func(t *testing.T, cfg *envConfig)
+	}{
+		{
+			name: "string",
+			env:  map[string]string{"DGGARCHIVER_SERVICE_NAME": "archiver"},
+			check: func(t *testing.T, cfg *envConfig) {
+				if cfg.Service.Name != "archiver" {
+					t.Errorf("name = %q", cfg.Service.Name)
+				}
+			},
+		},
+		{
+			name: "nested",
+			env:  map[string]string{"DGGARCHIVER_SERVICE_PLATFORM_PASSWORD": "hunter2", "DGGARCHIVER_NATS_HOST": "nats://nats:4222"},
+			check: func(t *testing.T, cfg *envConfig) {
+				if cfg.Service.Platform.Password != "hunter2" || cfg.NATS.Host != "nats://nats:4222" {
+					t.Errorf("password = %q, host = %q", cfg.Service.Platform.Password, cfg.NATS.Host)
+				}
+			},
+		},
+		{
+			name: "bool, int and duration",
+			env:  map[string]string{"DGGARCHIVER_SERVICE_ENABLED": "yes", "DGGARCHIVER_SERVICE_COUNT": "3", "DGGARCHIVER_SERVICE_TIMEOUT": "1m30s"},
+			check: func(t *testing.T, cfg *envConfig) {
+				if !cfg.Service.Enabled || cfg.Service.Count != 3 || cfg.Service.Timeout != 90*time.Second {
+					t.Errorf("enabled = %v, count = %d, timeout = %s", cfg.Service.Enabled, cfg.Service.Count, cfg.Service.Timeout)
+				}
+			},
+		},
+		{
+			name: "list",
+			env:  map[string]string{"DGGARCHIVER_SERVICE_SERVICES": `["discord://a@b", "telegram://tok@telegram?chats=@a,@b"]`},
+			check: func(t *testing.T, cfg *envConfig) {
+				if want := []string{"discord://a@b", "telegram://tok@telegram?chats=@a,@b"}; !reflect.DeepEqual(cfg.Service.Services, want) {
+					t.Errorf("services = %q, want %q", cfg.Service.Services, want)
+				}
+			},
+		},
+		{
+			name: "single item list",
+			env:  map[string]string{"DGGARCHIVER_SERVICE_SERVICES": "telegram://tok@telegram?chats=@a,@b"},
+			check: func(t *testing.T, cfg *envConfig) {
+				if want := []string{"telegram://tok@telegram?chats=@a,@b"}; !reflect.DeepEqual(cfg.Service.Services, want) {
+					t.Errorf("services = %q, want %q", cfg.Service.Services, want)
+				}
+			},
+		},
+		{
+			name: "indexed list",
+			env: map[string]string{
+				"DGGARCHIVER_SERVICE_SERVICES_0": "discord://a@b",
+				"DGGARCHIVER_SERVICE_SERVICES_1": "telegram://tok@telegram?chats=@a,@b",
+				"DGGARCHIVER_SERVICE_SERVICES_3": "ignored://after@gap",
+			},
+			check: func(t *testing.T, cfg *envConfig) {
+				if want := []string{"discord://a@b", "telegram://tok@telegram?chats=@a,@b"}; !reflect.DeepEqual(cfg.Service.Services, want) {
+					t.Errorf("services = %q, want %q", cfg.Service.Services, want)
+				}
+			},
+		},
+		{
+			name: "empty list",
+			env:  map[string]string{"DGGARCHIVER_SERVICE_SERVICES": ""},
+			check: func(t *testing.T, cfg *envConfig) {
+				if len(cfg.Service.Services) != 0 {
+					t.Errorf("services = %q, want none", cfg.Service.Services)
+				}
+			},
+		},
+		{
+			name: "map",
+			env:  map[string]string{"DGGARCHIVER_SERVICE_FILTERS": `{"(?i)rerun": skip, "x{1,3}": upload}`},
+			check: func(t *testing.T, cfg *envConfig) {
+				if want := map[string]string{"(?i)rerun": "skip", "x{1,3}": "upload"}; !reflect.DeepEqual(cfg.Service.Filters, want) {
+					t.Errorf("filters = %v, want %v", cfg.Service.Filters, want)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			cfg := newEnvConfig()
+			cfg.Service.Services = []string{"example://old"}
+			if err := ApplyEnv(cfg); err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestApplyEnvInvalid(t *testing.T) {
+	t.Setenv("DGGARCHIVER_SERVICE_COUNT", "three")
+	t.Setenv("DGGARCHIVER_SERVICE_ENABLED", "maybe")
+	t.Setenv("DGGARCHIVER_SERVICE_FILTERS", "rerun=skip")
+
+	err := ApplyEnv(newEnvConfig())
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("ApplyEnv() = %v, want a *ValidationError", err)
+	}
+	var keys []string
+	for _, fe := range verr.Errors {
+		keys = append(keys, fe.Key)
+	}
+	if want := []string{"service.enabled", "service.count", "service.filters"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("invalid keys = %q, want %q", keys, want)
+	}
+}
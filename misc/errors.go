@@ -0,0 +1,149 @@
+package misc
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+var (
+	ErrNotSet  = errors.New("config variable not set")
+	ErrInvalid = errors.New("invalid config variable")
+	// ErrNotReloadable is returned when a reloaded config changes a key
+	// that is only read on startup.
+	ErrNotReloadable = errors.New("config variable can't be changed without a restart")
+)
+
+// Position is the location of a key in a config file.
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	if p.Line == 0 {
+		return p.File
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// FieldError describes a problem with a single config key. Position points
+// at the key, or at its closest parent if the key is missing.
+type FieldError struct {
+	Key string
+	Err error
+	Position
+}
+
+func (e *FieldError) Error() string {
+	msg := e.Err.Error()
+	if e.Key != "" {
+		msg = fmt.Sprintf("%s: %s", e.Key, msg)
+	}
+	if e.Line > 0 {
+		msg = fmt.Sprintf("%s: %s", e.Position, msg)
+	}
+	return msg
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError aggregates every problem found while validating a config,
+// so that all of them can be reported at once.
+type ValidationError struct {
+	Errors []*FieldError
+}
+
+func (e *ValidationError) Add(key string, err error) {
+	e.Errors = append(e.Errors, &FieldError{Key: key, Err: err})
+}
+
+func (e *ValidationError) NotSet(key string) {
+	e.Add(key, ErrNotSet)
+}
+
+func (e *ValidationError) Invalid(key string) {
+	e.Add(key, ErrInvalid)
+}
+
+// Err returns nil if no errors were collected, and e otherwise.
+func (e *ValidationError) Err() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Error returns a report listing every error on its own line.
+func (e *ValidationError) Error() string {
+	var sb strings.Builder
+	sb.WriteString("invalid config:")
+	for _, fe := range e.Errors {
+		sb.WriteString("\n  ")
+		sb.WriteString(fe.Error())
+	}
+	return sb.String()
+}
+
+func (e *ValidationError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		errs = append(errs, fe)
+	}
+	return errs
+}
+
+// Validator collects the errors of a config, naming each field by its key
+// path derived from the yaml tags of root.
+type Validator struct {
+	root any
+	errs ValidationError
+}
+
+// NewValidator returns a Validator for root, a pointer to a service config.
+func NewValidator(root any) *Validator {
+	return &Validator{root: root}
+}
+
+// Add records err for field, a pointer to a value inside the config.
+func (v *Validator) Add(field any, err error) {
+	v.errs.Add(KeyOf(v.root, field), err)
+}
+
+func (v *Validator) NotSet(field any) {
+	v.Add(field, ErrNotSet)
+}
+
+func (v *Validator) Invalid(field any) {
+	v.Add(field, ErrInvalid)
+}
+
+func (v *Validator) Err() error {
+	return v.errs.Err()
+}
+
+// LogFatal logs err, listing every bad key if it is a ValidationError,
+// and exits.
+func LogFatal(err error) {
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		for _, fe := range verr.Errors {
+			attrs := []any{}
+			if fe.Key != "" {
+				attrs = append(attrs, slog.String("var", fe.Key))
+			}
+			if fe.Line > 0 {
+				attrs = append(attrs, slog.String("pos", fe.Position.String()))
+			}
+			slog.Error(fe.Err.Error(), attrs...)
+		}
+	} else {
+		slog.Error("unable to load config", slog.Any("err", err))
+	}
+	os.Exit(1)
+}
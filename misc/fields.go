@@ -0,0 +1,111 @@
+package misc
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+)
+
+// errStopWalk is returned by WalkFields callbacks to stop walking early.
+var errStopWalk = errors.New("stop walk")
+
+// errSkipFields is returned by WalkFields callbacks to skip the fields of
+// the struct passed to them.
+var errSkipFields = errors.New("skip fields")
+
+// Field is a config value reachable from a service config.
+type Field struct {
+	// Path holds the YAML keys leading to the field.
+	Path   []string
+	Struct reflect.StructField
+	Value  reflect.Value
+}
+
+// Key returns the dotted config key of the field, e.g. nats.host.
+func (f Field) Key() string {
+	return strings.Join(f.Path, ".")
+}
+
+// KeyOf returns the config key of field, a pointer to a value inside root,
+// e.g. KeyOf(&cfg, &cfg.NATS.Host) returns nats.host. It returns "" if
+// field isn't part of root.
+func KeyOf(root, field any) string {
+	fv := reflect.ValueOf(field)
+	if fv.Kind() != reflect.Pointer {
+		return ""
+	}
+
+	key := ""
+	_ = WalkFields(root, func(f Field) error {
+		if f.Value.CanAddr() && f.Value.Addr().Pointer() == fv.Pointer() && f.Value.Type() == fv.Type().Elem() {
+			key = f.Key()
+			return errStopWalk
+		}
+		return nil
+	})
+	return key
+}
+
+// FieldName returns the YAML key of a struct field following the yaml
+// package rules: the tag name if set, the lowercased field name otherwise.
+// ok is false for fields that aren't decoded from YAML.
+func FieldName(field reflect.StructField) (name string, ok bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+	tag := field.Tag.Get("yaml")
+	if tag == "-" {
+		return "", false
+	}
+	name, _, _ = strings.Cut(tag, ",")
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	return name, true
+}
+
+// WalkFields calls fn for every YAML field reachable from v, which must be
+// a pointer to a struct. Nested structs are passed to fn before their
+// fields are walked, unless fn returns errSkipFields; nil struct pointers
+// are skipped.
+func WalkFields(v any, fn func(f Field) error) error {
+	return walkFields(reflect.ValueOf(v).Elem(), nil, fn)
+}
+
+func walkFields(v reflect.Value, path []string, fn func(f Field) error) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name, ok := FieldName(sf)
+		if !ok {
+			continue
+		}
+
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Pointer {
+			if fv.IsNil() || fv.Elem().Kind() != reflect.Struct {
+				continue
+			}
+			fv = fv.Elem()
+		}
+
+		f := Field{
+			Path:   append(path[:len(path):len(path)], name),
+			Struct: sf,
+			Value:  fv,
+		}
+		err := fn(f)
+		if err == errSkipFields {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if fv.Kind() == reflect.Struct {
+			if err := walkFields(fv, f.Path, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package misc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Formats of config files.
+const (
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+	FormatTOML = "toml"
+)
+
+// ConfigFormat returns the format set by $CONFIG_FORMAT for the files
+// listed in $CONFIG, or "" if it is unset.
+func ConfigFormat() (string, error) {
+	format := strings.ToLower(os.Getenv("CONFIG_FORMAT"))
+	switch format {
+	case "", FormatYAML, FormatJSON, FormatTOML:
+		return format, nil
+	}
+	return "", fmt.Errorf("unsupported CONFIG_FORMAT %q, must be one of yaml, json or toml", format)
+}
+
+// FormatOf returns the format of the config file at path given by its
+// extension, defaulting to YAML.
+func FormatOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return FormatJSON
+	case ".toml":
+		return FormatTOML
+	}
+	return FormatYAML
+}
+
+// ParseConfig parses a config file in format into a YAML document node.
+// JSON is parsed as YAML, which it is a subset of, so that the keys keep
+// their position. TOML keys have none and are sorted.
+func ParseConfig(data []byte, format string) (*yaml.Node, error) {
+	var doc yaml.Node
+	if format != FormatTOML {
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		return &doc, nil
+	}
+
+	var m map[string]any
+	if err := toml.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	doc.Kind = yaml.DocumentNode
+	if len(m) > 0 {
+		doc.Content = []*yaml.Node{valueNode(m)}
+	}
+	return &doc, nil
+}
+
+// EncodeConfig encodes the YAML node n as a config file in format.
+func EncodeConfig(n *yaml.Node, format string) ([]byte, error) {
+	if format == FormatYAML {
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(n); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	var v map[string]any
+	if err := n.Decode(&v); err != nil {
+		return nil, err
+	}
+	if format == FormatJSON {
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(b, '\n'), nil
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// valueNode converts a value decoded from TOML to a YAML node.
+func valueNode(v any) *yaml.Node {
+	switch v := v.(type) {
+	case map[string]any:
+		n := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k}, valueNode(v[k]))
+		}
+		return n
+	case []map[string]any:
+		n := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, item := range v {
+			n.Content = append(n.Content, valueNode(item))
+		}
+		return n
+	case []any:
+		n := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, item := range v {
+			n.Content = append(n.Content, valueNode(item))
+		}
+		return n
+	case string:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v}
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(v)}
+	case int64:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.FormatInt(v, 10)}
+	case float64:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!float", Value: strconv.FormatFloat(v, 'g', -1, 64)}
+	case time.Time:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!timestamp", Value: v.Format(time.RFC3339Nano)}
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: fmt.Sprint(v)}
+}
+
+func isConfigFile(name string) bool {
+	switch filepath.Ext(name) {
+	case ".yaml", ".yml", ".json", ".toml":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package misc
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFormatOf(t *testing.T) {
+	tests := map[string]string{
+		"config.yaml":      FormatYAML,
+		"config.yml":       FormatYAML,
+		"config.json":      FormatJSON,
+		"conf.d/10.TOML":   FormatTOML,
+		"config":           FormatYAML,
+		"/run/config.toml": FormatTOML,
+	}
+	for path, want := range tests {
+		if got := FormatOf(path); got != want {
+			t.Errorf("FormatOf(%s) = %s, want %s", path, got, want)
+		}
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	want := map[string]any{
+		"nats": map[string]any{"host": "localhost", "topic": "archiver"},
+		"uploader": map[string]any{
+			"parallel_uploads": true,
+			"filters":          map[string]any{"(?i)rerun": "skip"},
+			"sqlite":           map[string]any{"uri": "vods.sqlite"},
+		},
+		"notifier": map[string]any{"platforms": map[string]any{"kick": map[string]any{
+			"refresh_time": 5,
+			"tags":         []any{"kick", "live"},
+		}}},
+	}
+	inputs := map[string]string{
+		FormatYAML: `
+nats: {host: localhost, topic: archiver}
+uploader:
+  parallel_uploads: true
+  filters: {'(?i)rerun': skip}
+  sqlite: {uri: vods.sqlite}
+notifier: {platforms: {kick: {refresh_time: 5, tags: [kick, live]}}}
+`,
+		FormatJSON: `{
+  "nats": {"host": "localhost", "topic": "archiver"},
+  "uploader": {"parallel_uploads": true, "filters": {"(?i)rerun": "skip"}, "sqlite": {"uri": "vods.sqlite"}},
+  "notifier": {"platforms": {"kick": {"refresh_time": 5, "tags": ["kick", "live"]}}}
+}`,
+		FormatTOML: `
+[nats]
+host = "localhost"
+topic = "archiver"
+
+[uploader]
+parallel_uploads = true
+filters = { "(?i)rerun" = "skip" }
+sqlite = { uri = "vods.sqlite" }
+
+[notifier.platforms.kick]
+refresh_time = 5
+tags = ["kick", "live"]
+`,
+	}
+
+	for format, input := range inputs {
+		t.Run(format, func(t *testing.T) {
+			doc, err := ParseConfig([]byte(input), format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]any
+			if err := doc.Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("parsed %v, want %v", got, want)
+			}
+
+			// Encoding in any format and parsing it back gives the same
+			// config
+			for _, to := range []string{FormatYAML, FormatJSON, FormatTOML} {
+				out, err := EncodeConfig(doc, to)
+				if err != nil {
+					t.Fatal(err)
+				}
+				back, err := ParseConfig(out, to)
+				if err != nil {
+					t.Fatalf("%s: %v\n%s", to, err, out)
+				}
+				var again map[string]any
+				if err := back.Decode(&again); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(again, want) {
+					t.Errorf("round trip through %s gave %v, want %v", to, again, want)
+				}
+			}
+		})
+	}
+}
+
+// TestConfigFormatScope checks that $CONFIG_FORMAT only applies to the
+// files listed in $CONFIG, not to the files they include or the files of a
+// conf.d directory.
+func TestConfigFormatScope(t *testing.T) {
+	dir := t.TempDir()
+	writeConfig(t, dir, "base.yaml", "nats:\n  host: localhost\n")
+	writeConfig(t, dir, "conf.d/10-topic.yaml", "nats:\n  topic: archiver\n")
+	config := writeConfig(t, dir, "config", `
+include = ["base.yaml"]
+
+[uploader]
+verbose = true
+`)
+	t.Setenv("CONFIG_FORMAT", "toml")
+
+	doc, err := ReadDocument(config + string(os.PathListSeparator) + filepath.Join(dir, "conf.d"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"nats":     map[string]any{"host": "localhost", "topic": "archiver"},
+		"uploader": map[string]any{"verbose": true},
+	}
+	if got := decodeMap(t, doc); !reflect.DeepEqual(got, want) {
+		t.Errorf("merged %v, want %v", got, want)
+	}
+
+	files, err := ConfigFiles(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantFiles := []ConfigFile{{Path: config, Format: FormatTOML}, {Path: filepath.Join(dir, "base.yaml"), Format: FormatYAML}}
+	if !reflect.DeepEqual(files, wantFiles) {
+		t.Errorf("ConfigFiles() = %v, want %v", files, wantFiles)
+	}
+}
+
+func TestConfigFormatInvalid(t *testing.T) {
+	t.Setenv("CONFIG_FORMAT", "ini")
+	if _, err := ConfigFormat(); err == nil {
+		t.Error("ConfigFormat() = nil, want an error")
+	}
+}
@@ -0,0 +1,140 @@
+package misc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var ErrUndefinedVar = errors.New("undefined variable")
+
+// Interpolate replaces the ${VAR} references in the values of the given
+// top level sections, or of every section if none are given, with the
+// environment variable VAR or, if unset, the key VAR of the top level vars
+// map. ${VAR:-default} falls back to default if VAR is unset or empty, and
+// $$ is a literal $. Decrypted values and values read from a K8s Secret
+// are left as is. The values of vars can only reference environment
+// variables and are only expanded when used, so that a var set for another
+// service doesn't fail the others.
+func (d *Document) Interpolate(sections ...string) error {
+	vars := map[string]*yaml.Node{}
+	if n := valueOf(d.Root, "vars"); n != nil {
+		if err := n.Decode(&map[string]string{}); err != nil {
+			return fmt.Errorf("invalid vars: %w", err)
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			vars[n.Content[i].Value] = n.Content[i+1]
+		}
+	}
+
+	lookup := func(name string) (string, bool, error) {
+		if value, ok := os.LookupEnv(name); ok {
+			return value, true, nil
+		}
+		n, ok := vars[name]
+		if !ok {
+			return "", false, nil
+		}
+		value, err := expand(n.Value, lookupEnv)
+		if err != nil {
+			return "", true, fmt.Errorf("vars.%s: %w", name, err)
+		}
+		return value, true, nil
+	}
+
+	var verr ValidationError
+	for i := 0; i+1 < len(d.Root.Content); i += 2 {
+		key := d.Root.Content[i].Value
+		if key == "vars" || len(sections) > 0 && !slices.Contains(sections, key) {
+			continue
+		}
+		d.interpolate(d.Root.Content[i+1], []string{key}, lookup, &verr)
+	}
+	return verr.Err()
+}
+
+func lookupEnv(name string) (string, bool, error) {
+	value, ok := os.LookupEnv(name)
+	return value, ok, nil
+}
+
+func (d *Document) interpolate(n *yaml.Node, path []string, lookup func(string) (string, bool, error), verr *ValidationError) {
+	if d.verbatim[n] {
+		return
+	}
+	switch n.Kind {
+	case yaml.ScalarNode:
+		if !strings.Contains(n.Value, "$") {
+			return
+		}
+		value, err := expand(n.Value, lookup)
+		if err != nil {
+			verr.Errors = append(verr.Errors, &FieldError{
+				Key:      strings.Join(path, "."),
+				Err:      err,
+				Position: Position{File: d.files[n], Line: n.Line, Column: n.Column},
+			})
+			return
+		}
+		n.Value = value
+		// Let plain values resolve to the type of what they now hold, e.g.
+		// an int for refresh_time: ${REFRESH}
+		if n.Style == 0 {
+			n.Tag = ""
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			d.interpolate(n.Content[i+1], append(path[:len(path):len(path)], n.Content[i].Value), lookup, verr)
+		}
+	case yaml.SequenceNode:
+		for _, item := range n.Content {
+			d.interpolate(item, path, lookup, verr)
+		}
+	}
+}
+
+func expand(s string, lookup func(string) (string, bool, error)) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '$')
+		if i < 0 || i == len(s)-1 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		b.WriteString(s[:i])
+		s = s[i+1:]
+
+		switch s[0] {
+		case '$':
+			b.WriteByte('$')
+			s = s[1:]
+			continue
+		case '{':
+		default:
+			b.WriteByte('$')
+			continue
+		}
+
+		end := strings.IndexByte(s, '}')
+		if end < 0 {
+			return "", fmt.Errorf("%w: unterminated ${ in %q", ErrInvalid, s)
+		}
+		name, def, hasDefault := strings.Cut(s[1:end], ":-")
+		s = s[end+1:]
+
+		value, ok, err := lookup(name)
+		switch {
+		case err != nil:
+			return "", err
+		case hasDefault && value == "":
+			value = def
+		case !ok:
+			return "", fmt.Errorf("%w %s", ErrUndefinedVar, name)
+		}
+		b.WriteString(value)
+	}
+}
@@ -0,0 +1,153 @@
+package misc
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	vars := map[string]string{"HOST": "nats.prod", "EMPTY": ""}
+	lookup := func(name string) (string, bool, error) {
+		value, ok := vars[name]
+		return value, ok, nil
+	}
+	tests := []struct {
+		s    string
+		want string
+		err  error
+	}{
+		{s: "nats://${HOST}:4222", want: "nats://nats.prod:4222"},
+		{s: "${MISSING:-localhost}", want: "localhost"},
+		{s: "${EMPTY:-localhost}", want: "localhost"},
+		{s: "${HOST:-localhost}", want: "nats.prod"},
+		{s: "${EMPTY}", want: ""},
+		{s: "pa$$word", want: "pa$word"},
+		{s: "$$${HOST}", want: "$nats.prod"},
+		{s: "$HOST", want: "$HOST"},
+		{s: "price: 5$", want: "price: 5$"},
+		{s: "${MISSING}", err: ErrUndefinedVar},
+		{s: "${HOST", err: ErrInvalid},
+	}
+	for _, tt := range tests {
+		got, err := expand(tt.s, lookup)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("expand(%q) = %v, want %v", tt.s, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("expand(%q) = %q, %v, want %q", tt.s, got, err, tt.want)
+		}
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	t.Setenv("NATS_HOST", "nats.prod")
+	t.Setenv("REFRESH", "30")
+	doc := readConfig(t, `
+vars:
+  topic: archiver
+  url: nats://${NATS_HOST}:4222
+nats:
+  host: ${url}
+  topic: ${topic}
+notifier:
+  platforms:
+    kick:
+      refresh_time: ${REFRESH}
+      channel: "${REFRESH}"
+      tags: ["${topic}", live]
+`)
+	if err := doc.Interpolate(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]any{
+		"host":  "nats://nats.prod:4222",
+		"topic": "archiver",
+	}
+	if got := decodeMap(t, doc)["nats"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("nats = %v, want %v", got, want)
+	}
+	kick := decodeMap(t, doc)["notifier"].(map[string]any)["platforms"].(map[string]any)["kick"].(map[string]any)
+	// Plain values take the type of what they hold, quoted ones stay strings
+	if kick["refresh_time"] != 30 || kick["channel"] != "30" {
+		t.Errorf("refresh_time = %#v, channel = %#v", kick["refresh_time"], kick["channel"])
+	}
+	if !reflect.DeepEqual(kick["tags"], []any{"archiver", "live"}) {
+		t.Errorf("tags = %v", kick["tags"])
+	}
+}
+
+func TestInterpolateEnvOverVars(t *testing.T) {
+	t.Setenv("topic", "from-env")
+	doc := readConfig(t, "vars:\n  topic: from-vars\nnats:\n  topic: ${topic}\n")
+	if err := doc.Interpolate(); err != nil {
+		t.Fatal(err)
+	}
+	if got := decodeMap(t, doc)["nats"].(map[string]any)["topic"]; got != "from-env" {
+		t.Errorf("topic = %v, want from-env", got)
+	}
+}
+
+func TestInterpolateSections(t *testing.T) {
+	doc := readConfig(t, `
+vars:
+  db: file://${UPLOADER_DB}
+nats:
+  topic: archiver
+controller:
+  worker_image: worker:${TAG:-main}
+uploader:
+  sqlite:
+    uri: ${db}
+`)
+	// Variables used by the uploader only don't fail the controller
+	if err := doc.Interpolate("controller", "nats"); err != nil {
+		t.Fatal(err)
+	}
+	m := decodeMap(t, doc)
+	if got := m["controller"].(map[string]any)["worker_image"]; got != "worker:main" {
+		t.Errorf("worker_image = %v, want worker:main", got)
+	}
+	if got := m["uploader"].(map[string]any)["sqlite"].(map[string]any)["uri"]; got != "${db}" {
+		t.Errorf("uploader.sqlite.uri = %v, want it left as is", got)
+	}
+
+	err := doc.Interpolate("uploader")
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 {
+		t.Fatalf("Interpolate() = %v, want a single error", err)
+	}
+	fe := verr.Errors[0]
+	if fe.Key != "uploader.sqlite.uri" || fe.Line != 9 || !errors.Is(fe, ErrUndefinedVar) || !strings.Contains(fe.Error(), "UPLOADER_DB") {
+		t.Errorf("Interpolate() = %v, want UPLOADER_DB undefined at uploader.sqlite.uri line 9", fe)
+	}
+}
+
+func TestInterpolateErrors(t *testing.T) {
+	doc := readConfig(t, `
+nats:
+  host: ${NATS_HOST}
+  topic: ${TOPIC
+`)
+	err := doc.Interpolate()
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 2 {
+		t.Fatalf("Interpolate() = %v, want 2 errors", err)
+	}
+	if fe := verr.Errors[0]; fe.Key != "nats.host" || fe.Line != 2 || !errors.Is(fe, ErrUndefinedVar) {
+		t.Errorf("first error = %v", fe)
+	}
+	if fe := verr.Errors[1]; fe.Key != "nats.topic" || fe.Line != 3 || !errors.Is(fe, ErrInvalid) {
+		t.Errorf("second error = %v", fe)
+	}
+
+	doc = readConfig(t, "vars:\n  list: [a, b]\n")
+	if err := doc.Interpolate(); err == nil || !strings.Contains(err.Error(), "invalid vars") {
+		t.Errorf("Interpolate() = %v, want invalid vars", err)
+	}
+}
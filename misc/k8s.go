@@ -0,0 +1,301 @@
+package misc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// namespaceFile holds the namespace of the pod a service runs in.
+const namespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// K8sSource is a config stored in a K8s ConfigMap, along with a Secret
+// whose keys are the dotted config keys of secret values, e.g.
+// uploader.platforms.odysee.password. The value of a list key is a YAML
+// list, or its single item.
+type K8sSource struct {
+	Client    kubernetes.Interface
+	Namespace string
+	ConfigMap string
+	// Key is the key of the ConfigMap holding the config, its extension
+	// gives its format.
+	Key    string
+	Secret string
+	// Timeout bounds reading the ConfigMap and the Secret.
+	Timeout time.Duration
+}
+
+// K8sSourceFromEnv returns the source set by $CONFIG_CONFIGMAP and
+// $CONFIG_SECRET, or nil if neither is set. $CONFIG_CONFIGMAP_KEY defaults
+// to config.yaml, $CONFIG_NAMESPACE to the namespace of the pod and
+// $CONFIG_TIMEOUT to DefaultTimeout. client defaults to the in-cluster
+// client.
+func K8sSourceFromEnv(client kubernetes.Interface) (*K8sSource, error) {
+	s := &K8sSource{
+		Client:    client,
+		Namespace: os.Getenv("CONFIG_NAMESPACE"),
+		ConfigMap: os.Getenv("CONFIG_CONFIGMAP"),
+		Key:       os.Getenv("CONFIG_CONFIGMAP_KEY"),
+		Secret:    os.Getenv("CONFIG_SECRET"),
+	}
+	if s.ConfigMap == "" && s.Secret == "" {
+		return nil, nil
+	}
+	if s.Key == "" {
+		s.Key = "config.yaml"
+	}
+	var err error
+	if s.Timeout, err = configTimeout(); err != nil {
+		return nil, err
+	}
+	if s.Namespace == "" {
+		ns, err := os.ReadFile(namespaceFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to find the config namespace, set CONFIG_NAMESPACE: %w", err)
+		}
+		s.Namespace = strings.TrimSpace(string(ns))
+	}
+	if s.Client == nil {
+		cfg, err := rest.InClusterConfig()
+		if err != nil {
+			return nil, fmt.Errorf("unable to create in-cluster config: %w", err)
+		}
+		if s.Client, err = kubernetes.NewForConfig(cfg); err != nil {
+			return nil, fmt.Errorf("unable to create k8s client: %w", err)
+		}
+	}
+	return s, nil
+}
+
+func (s *K8sSource) String() string {
+	return fmt.Sprintf("k8s://%s/configmap/%s/%s", s.Namespace, s.ConfigMap, s.Key)
+}
+
+func (s *K8sSource) secretName() string {
+	return fmt.Sprintf("k8s://%s/secret/%s", s.Namespace, s.Secret)
+}
+
+// Watch implements Remote with informers on the ConfigMap and the Secret.
+func (s *K8sSource) Watch(ctx context.Context, changed func()) error {
+	var synced atomic.Bool
+	handler := func(name string) cache.ResourceEventHandlerFuncs {
+		matches := func(obj any) bool {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			m, err := meta(obj)
+			return err == nil && m.GetName() == name
+		}
+		return cache.ResourceEventHandlerFuncs{
+			// Objects listed when the informer starts are added too
+			AddFunc: func(obj any) {
+				if synced.Load() && matches(obj) {
+					changed()
+				}
+			},
+			UpdateFunc: func(old, obj any) {
+				o, errOld := meta(old)
+				n, errNew := meta(obj)
+				if errOld == nil && errNew == nil && o.GetResourceVersion() != n.GetResourceVersion() && matches(obj) {
+					changed()
+				}
+			},
+			DeleteFunc: func(obj any) {
+				if matches(obj) {
+					changed()
+				}
+			},
+		}
+	}
+
+	var factories []informers.SharedInformerFactory
+	factory := func(name string) informers.SharedInformerFactory {
+		f := informers.NewSharedInformerFactoryWithOptions(s.Client, 0,
+			informers.WithNamespace(s.Namespace),
+			informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+				o.FieldSelector = "metadata.name=" + name
+			}),
+		)
+		factories = append(factories, f)
+		return f
+	}
+	if s.ConfigMap != "" {
+		if _, err := factory(s.ConfigMap).Core().V1().ConfigMaps().Informer().AddEventHandler(handler(s.ConfigMap)); err != nil {
+			return fmt.Errorf("unable to watch config in %s: %w", s, err)
+		}
+	}
+	if s.Secret != "" {
+		if _, err := factory(s.Secret).Core().V1().Secrets().Informer().AddEventHandler(handler(s.Secret)); err != nil {
+			return fmt.Errorf("unable to watch config in %s: %w", s.secretName(), err)
+		}
+	}
+
+	for _, f := range factories {
+		f.Start(ctx.Done())
+	}
+	for _, f := range factories {
+		f.WaitForCacheSync(ctx.Done())
+	}
+	synced.Store(true)
+
+	<-ctx.Done()
+	for _, f := range factories {
+		f.Shutdown()
+	}
+	return nil
+}
+
+func meta(obj any) (metav1.Object, error) {
+	switch obj := obj.(type) {
+	case *corev1.ConfigMap:
+		return obj, nil
+	case *corev1.Secret:
+		return obj, nil
+	}
+	return nil, fmt.Errorf("unexpected object %T", obj)
+}
+
+// read merges the config of the ConfigMap over the document, then sets
+// the keys of the Secret. Secret values are taken verbatim, a $ in a
+// password isn't the start of a ${VAR} reference.
+func (s *K8sSource) read(ctx context.Context, d *Document) error {
+	if s.ConfigMap != "" {
+		cm, err := s.Client.CoreV1().ConfigMaps(s.Namespace).Get(ctx, s.ConfigMap, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("unable to read config from %s: %w", s, err)
+		}
+		data, ok := cm.Data[s.Key]
+		if !ok {
+			return fmt.Errorf("unable to read config from %s: no key %s", s, s.Key)
+		}
+		if err := d.merge([]byte(data), path.Base(s.Key), s.String()); err != nil {
+			return err
+		}
+	}
+
+	if s.Secret != "" {
+		secret, err := s.Client.CoreV1().Secrets(s.Namespace).Get(ctx, s.Secret, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("unable to read config from %s: %w", s.secretName(), err)
+		}
+		keys := make([]string, 0, len(secret.Data))
+		for k := range secret.Data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			keyPath := strings.Split(k, ".")
+			value, err := d.secretValue(keyPath, secret.Data[k])
+			if err != nil {
+				return fmt.Errorf("invalid value of key %s in %s: %w", k, s.secretName(), err)
+			}
+			d.record(value, s.secretName())
+			d.verbatim[value] = true
+			if err := d.setPath(keyPath, value, s.secretName()); err != nil {
+				return fmt.Errorf("invalid key %s in %s: %w", k, s.secretName(), err)
+			}
+		}
+	}
+
+	d.Remotes = append(d.Remotes, s)
+	return nil
+}
+
+// secretValue returns the node of the value of a Secret key. Values are
+// strings, unless the key is a list, which is given as a YAML list such as
+// ["discord://token@id", "telegram://token@telegram?chats=@a,@b"], or as a
+// single item.
+func (d *Document) secretValue(path []string, data []byte) (*yaml.Node, error) {
+	value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: string(data)}
+	if t := fieldType(d.configType, path); t == nil || t.Kind() != reflect.Slice {
+		return value, nil
+	}
+
+	trimmed := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(trimmed, "[") && !strings.HasPrefix(trimmed, "- ") {
+		return &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: []*yaml.Node{value}}, nil
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.SequenceNode {
+		return nil, errors.New("expected a list")
+	}
+	return doc.Content[0], nil
+}
+
+// fieldType returns the type of the field at path in t, a config type, or
+// nil if there is none.
+func fieldType(t reflect.Type, path []string) reflect.Type {
+	for _, key := range path {
+		if t == nil {
+			return nil
+		}
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		switch t.Kind() {
+		case reflect.Struct:
+			var field reflect.Type
+			for i := 0; i < t.NumField() && field == nil; i++ {
+				sf := t.Field(i)
+				if name, ok := FieldName(sf); ok && (name == key || slices.Contains(Aliases(sf), key)) {
+					field = sf.Type
+				}
+			}
+			t = field
+		case reflect.Map:
+			t = t.Elem()
+		default:
+			return nil
+		}
+	}
+	return t
+}
+
+// setPath sets the value at path in the root map, adding the missing maps.
+// The keys it adds are located in file.
+func (d *Document) setPath(path []string, value *yaml.Node, file string) error {
+	n := d.Root
+	for i, key := range path {
+		if n.Kind != yaml.MappingNode {
+			return fmt.Errorf("%s is not a map", strings.Join(path[:i], "."))
+		}
+		keyNode := &yaml.Node{Kind: yaml.ScalarNode, Value: key}
+		d.record(keyNode, file)
+		j := keyIndex(n, key)
+		if i == len(path)-1 {
+			if j < 0 {
+				n.Content = append(n.Content, keyNode, value)
+			} else {
+				n.Content[j], n.Content[j+1] = keyNode, value
+			}
+			return nil
+		}
+		if j < 0 {
+			child := &yaml.Node{Kind: yaml.MappingNode}
+			d.record(child, file)
+			n.Content = append(n.Content, keyNode, child)
+			j = len(n.Content) - 2
+		}
+		n = n.Content[j+1]
+	}
+	return nil
+}
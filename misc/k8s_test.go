@@ -0,0 +1,221 @@
+package misc
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func k8sObjects(config string, secret map[string]string) (*corev1.ConfigMap, *corev1.Secret) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "archiver", Namespace: "dgg", ResourceVersion: "1"},
+		Data:       map[string]string{"config.yaml": config},
+	}
+	s := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "archiver", Namespace: "dgg", ResourceVersion: "1"},
+		Data:       map[string][]byte{},
+	}
+	for k, v := range secret {
+		s.Data[k] = []byte(v)
+	}
+	return cm, s
+}
+
+func TestK8sSourceRead(t *testing.T) {
+	cm, secret := k8sObjects("nats:\n  host: nats.prod\nuploader:\n  sqlite:\n    uri: ${DB:-vods.sqlite}\n", map[string]string{
+		"uploader.platforms.odysee.password": "pa$$word${",
+		"nats.topic":                         "archiver",
+	})
+	source := &K8sSource{
+		Client:    fake.NewSimpleClientset(cm, secret),
+		Namespace: "dgg",
+		ConfigMap: "archiver",
+		Key:       "config.yaml",
+		Secret:    "archiver",
+	}
+
+	doc := readConfig(t, "nats:\n  host: localhost\n  topic: from-file\n")
+	if err := source.read(context.Background(), doc); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Interpolate(); err != nil {
+		t.Fatal(err)
+	}
+
+	m := decodeMap(t, doc)
+	nats := m["nats"].(map[string]any)
+	if nats["host"] != "nats.prod" || nats["topic"] != "archiver" {
+		t.Errorf("nats = %v", nats)
+	}
+	uploader := m["uploader"].(map[string]any)
+	if uri := uploader["sqlite"].(map[string]any)["uri"]; uri != "vods.sqlite" {
+		t.Errorf("uploader.sqlite.uri = %v, want the ConfigMap to be interpolated", uri)
+	}
+	// Secret values are taken verbatim
+	if password := uploader["platforms"].(map[string]any)["odysee"].(map[string]any)["password"]; password != "pa$$word${" {
+		t.Errorf("password = %v, want pa$$word${", password)
+	}
+
+	positions := doc.Positions()
+	if pos := positions.Find("nats.host"); pos.File != "k8s://dgg/configmap/archiver/config.yaml" {
+		t.Errorf("nats.host at %s, want the ConfigMap", pos)
+	}
+	if pos := positions.Find("uploader.platforms.odysee.password"); pos.File != "k8s://dgg/secret/archiver" {
+		t.Errorf("password at %s, want the Secret", pos)
+	}
+	if pos := positions.Find("nats.topic"); pos.File != "k8s://dgg/secret/archiver" {
+		t.Errorf("nats.topic at %s, want the Secret that overrides the file", pos)
+	}
+	if len(doc.Remotes) != 1 {
+		t.Errorf("remotes = %v", doc.Remotes)
+	}
+}
+
+func TestK8sSourceReadLists(t *testing.T) {
+	type listConfig struct {
+		Notifier struct {
+			Notifications Notifications `yaml:"notifications"`
+		} `yaml:"notifier"`
+	}
+	tests := []struct {
+		name  string
+		value string
+		want  []string
+		err   string
+	}{
+		{"flow list", `["discord://token@id", "telegram://tok@telegram?chats=@a,@b"]`, []string{"discord://token@id", "telegram://tok@telegram?chats=@a,@b"}, ""},
+		{"block list", "- discord://token@id\n- ntfy://${topic}\n", []string{"discord://token@id", "ntfy://${topic}"}, ""},
+		{"single item", "telegram://tok@telegram?chats=@a,@b", []string{"telegram://tok@telegram?chats=@a,@b"}, ""},
+		{"invalid list", `["discord://token@id"`, nil, "invalid value of key notifier.notifications.services in k8s://dgg/secret/archiver"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, secret := k8sObjects("", map[string]string{"notifier.notifications.services": tt.value})
+			source := &K8sSource{Client: fake.NewSimpleClientset(secret), Namespace: "dgg", Secret: "archiver"}
+
+			doc := readConfig(t, "notifier:\n  notifications:\n    services: [discord://old@id]\n")
+			doc.configType = reflect.TypeOf(&listConfig{})
+			err := source.read(context.Background(), doc)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("read() = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := doc.Interpolate(); err != nil {
+				t.Fatal(err)
+			}
+			var cfg listConfig
+			if _, err := doc.Decode(&cfg); err != nil {
+				t.Fatal(err)
+			}
+			if got := cfg.Notifier.Notifications.Services; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("services = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestK8sSourceReadErrors(t *testing.T) {
+	cm, secret := k8sObjects("nats:\n  host: nats.prod\n", map[string]string{"nats.host.port": "4222"})
+	tests := []struct {
+		name   string
+		source K8sSource
+		want   string
+	}{
+		{"missing ConfigMap", K8sSource{ConfigMap: "missing", Key: "config.yaml"}, "unable to read config from k8s://dgg/configmap/missing"},
+		{"missing key", K8sSource{ConfigMap: "archiver", Key: "config.json"}, "no key config.json"},
+		{"missing Secret", K8sSource{Secret: "missing"}, "unable to read config from k8s://dgg/secret/missing"},
+		{"invalid key", K8sSource{Secret: "archiver"}, "invalid key nats.host.port"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.source.Client = fake.NewSimpleClientset(cm, secret)
+			tt.source.Namespace = "dgg"
+			err := tt.source.read(context.Background(), readConfig(t, "nats:\n  host: localhost\n"))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("read() = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestK8sSourceWatch(t *testing.T) {
+	cm, secret := k8sObjects("nats:\n  host: nats.prod\n", map[string]string{"nats.topic": "archiver"})
+	other, _ := k8sObjects("", nil)
+	other.Name = "other"
+	client := fake.NewSimpleClientset(cm, secret, other)
+	source := &K8sSource{Client: client, Namespace: "dgg", ConfigMap: "archiver", Key: "config.yaml", Secret: "archiver"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changed := make(chan struct{}, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- source.Watch(ctx, func() { changed <- struct{}{} })
+	}()
+
+	expect := func(what string, want bool) {
+		t.Helper()
+		select {
+		case <-changed:
+			if !want {
+				t.Errorf("Watch() reported %s as a change", what)
+			}
+		case <-time.After(500 * time.Millisecond):
+			if want {
+				t.Errorf("Watch() didn't report %s", what)
+			}
+		}
+	}
+	// The objects listed on start aren't changes
+	expect("the initial objects", false)
+
+	cms := client.CoreV1().ConfigMaps("dgg")
+	cm = cm.DeepCopy()
+	cm.Data["config.yaml"] = "nats:\n  host: nats.staging\n"
+	cm.ResourceVersion = "2"
+	if _, err := cms.Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	expect("a ConfigMap update", true)
+
+	secret = secret.DeepCopy()
+	secret.Data["nats.topic"] = []byte("staging")
+	secret.ResourceVersion = "2"
+	if _, err := client.CoreV1().Secrets("dgg").Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	expect("a Secret update", true)
+
+	other = other.DeepCopy()
+	other.Data["config.yaml"] = "nats: {}\n"
+	other.ResourceVersion = "2"
+	if _, err := cms.Update(ctx, other, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	expect("an update of another ConfigMap", false)
+
+	if err := cms.Delete(ctx, "archiver", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	expect("the ConfigMap deletion", true)
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Watch() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watch() didn't return once ctx was done")
+	}
+}
@@ -0,0 +1,193 @@
+package misc
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// KVConfig locates the JetStream key-value entry holding the config.
+type KVConfig struct {
+	Bucket string `yaml:"bucket" desc:"JetStream key-value bucket the config is read from, merged over the config files"`
+	Key    string `yaml:"key" default:"config.yaml" desc:"key of the config in the bucket, its extension gives its format"`
+}
+
+// Remote is a config source outside of the file system, watched by
+// Watcher.Run.
+type Remote interface {
+	// Watch calls changed whenever the source changes, until ctx is done.
+	Watch(ctx context.Context, changed func()) error
+}
+
+// KVSource is a config stored in a NATS JetStream key-value bucket.
+type KVSource struct {
+	Host   string
+	Bucket string
+	Key    string
+	// Timeout bounds connecting to Host and reading the key.
+	Timeout time.Duration
+	// NATS is the connection shared by the service, used by Watch. If it
+	// is nil, Watch connects to Host.
+	NATS *nats.Conn
+}
+
+func (s *KVSource) String() string {
+	return fmt.Sprintf("nats-kv://%s/%s", s.Bucket, s.Key)
+}
+
+// Get returns the value of the key, within Timeout and the deadline of ctx,
+// and gives up once ctx is done.
+func (s *KVSource) Get(ctx context.Context, nc *nats.Conn) ([]byte, error) {
+	wait := s.Timeout
+	if deadline, ok := ctx.Deadline(); ok && (wait <= 0 || time.Until(deadline) < wait) {
+		wait = time.Until(deadline)
+	}
+
+	type result struct {
+		data []byte
+		err  error
+	}
+	read := make(chan result, 1)
+	go func() {
+		kv, err := s.keyValue(nc, wait)
+		if err != nil {
+			read <- result{err: err}
+			return
+		}
+		entry, err := kv.Get(s.Key)
+		if err != nil {
+			read <- result{err: fmt.Errorf("unable to read config from %s: %w", s, err)}
+			return
+		}
+		read <- result{data: entry.Value()}
+	}()
+	select {
+	case r := <-read:
+		return r.data, r.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("unable to read config from %s: %w", s, ctx.Err())
+	}
+}
+
+// Watch implements Remote.
+func (s *KVSource) Watch(ctx context.Context, changed func()) error {
+	nc := s.NATS
+	if nc == nil {
+		var err error
+		nc, err = nats.Connect(s.Host, nats.Timeout(s.Timeout))
+		if err != nil {
+			return &DependencyError{Dependency: "nats", Timeout: s.Timeout, Err: fmt.Errorf("unable to connect to NATS server: %w", err)}
+		}
+		defer nc.Close()
+	}
+
+	kv, err := s.keyValue(nc, s.Timeout)
+	if err != nil {
+		return err
+	}
+	w, err := kv.Watch(s.Key, nats.Context(ctx))
+	if err != nil {
+		return fmt.Errorf("unable to watch config in %s: %w", s, err)
+	}
+	defer func() { _ = w.Stop() }()
+
+	// The current value comes first and is followed by a nil entry
+	initial := true
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case entry, ok := <-w.Updates():
+			switch {
+			case !ok:
+				return nil
+			case entry == nil:
+				initial = false
+			case !initial:
+				changed()
+			}
+		}
+	}
+}
+
+func (s *KVSource) keyValue(nc *nats.Conn, wait time.Duration) (nats.KeyValue, error) {
+	var opts []nats.JSOpt
+	if wait > 0 {
+		opts = append(opts, nats.MaxWait(wait))
+	}
+	js, err := nc.JetStream(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to use JetStream: %w", err)
+	}
+	kv, err := js.KeyValue(s.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open config bucket %s: %w", s.Bucket, err)
+	}
+	return kv, nil
+}
+
+// readKV merges the config stored in the bucket set by nats.config over
+// the document, if any. The NATS connection is kept in o.NATSConnection to
+// be reused by the service, and by the source to watch the bucket, unless
+// o.SkipConnect is set. As profiles and
+// ${VAR} references aren't applied yet, nats.host and nats.config must be
+// set as is or through DGGARCHIVER_ environment variables.
+func (d *Document) readKV(o *Options) error {
+	var boot struct {
+		NATS NATSConfig `yaml:"nats"`
+	}
+	if err := d.Root.Decode(&boot); err != nil {
+		return fmt.Errorf("unable to unmarshall config yaml: %w", err)
+	}
+	if err := ApplyDefaults(&boot); err != nil {
+		return err
+	}
+	if err := ApplyEnv(&boot); err != nil {
+		return err
+	}
+	if boot.NATS.Config.Bucket == "" {
+		return nil
+	}
+	if err := ResolveSecrets(&boot); err != nil {
+		return err
+	}
+
+	source := &KVSource{Host: boot.NATS.Host, Bucket: boot.NATS.Config.Bucket, Key: boot.NATS.Config.Key, Timeout: boot.NATS.Timeout}
+	nc := o.NATSConnection
+	if nc == nil {
+		err := ConnectDependency(o.Context, "nats", source.Timeout, func(ctx context.Context) error {
+			var err error
+			nc, err = dialNATS(ctx, source.Host)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if o.SkipConnect {
+			defer nc.Close()
+		} else {
+			o.NATSConnection = nc
+		}
+	}
+	if nc == o.NATSConnection {
+		source.NATS = nc
+	}
+
+	var data []byte
+	err := ConnectDependency(o.Context, "nats", source.Timeout, func(ctx context.Context) error {
+		var err error
+		data, err = source.Get(ctx, nc)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := d.merge(data, path.Base(source.Key), source.String()); err != nil {
+		return err
+	}
+	d.Remotes = append(d.Remotes, source)
+	return nil
+}
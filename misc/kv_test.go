@@ -0,0 +1,260 @@
+package misc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+)
+
+// runJetStream starts a NATS server with JetStream and returns a
+// connection to it along with a bucket holding config.yaml.
+func runJetStream(t *testing.T, config string) (*server.Server, *nats.Conn, nats.KeyValue) {
+	t.Helper()
+	opts := natstest.DefaultTestOptions
+	opts.Port = server.RANDOM_PORT
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+	s := natstest.RunServer(&opts)
+	t.Cleanup(s.Shutdown)
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv, err := js.CreateKeyValue(&nats.KeyValueConfig{Bucket: "archiver"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kv.PutString("config.yaml", config); err != nil {
+		t.Fatal(err)
+	}
+	return s, nc, kv
+}
+
+func TestKVSourceGet(t *testing.T) {
+	s, nc, _ := runJetStream(t, "nats:\n  topic: archiver\n")
+	source := &KVSource{Host: s.ClientURL(), Bucket: "archiver", Key: "config.yaml", Timeout: time.Second}
+
+	data, err := source.Get(context.Background(), nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "nats:\n  topic: archiver\n" {
+		t.Errorf("Get() = %q", data)
+	}
+
+	for name, source := range map[string]*KVSource{
+		"missing key":    {Bucket: "archiver", Key: "missing.yaml", Timeout: time.Second},
+		"missing bucket": {Bucket: "missing", Key: "config.yaml", Timeout: time.Second},
+	} {
+		if _, err := source.Get(context.Background(), nc); err == nil {
+			t.Errorf("%s: Get() = nil, want an error", name)
+		}
+	}
+}
+
+func TestReadKV(t *testing.T) {
+	s, nc, _ := runJetStream(t, "nats:\n  topic: from-kv\nnotifier:\n  verbose: true\n")
+	config := "nats:\n  host: " + s.ClientURL() + "\n  topic: from-file\n  config:\n    bucket: archiver\n"
+
+	t.Run("reuses the connection", func(t *testing.T) {
+		doc := readConfig(t, config)
+		o := NewOptions(WithNATSConnection(nc))
+		if err := doc.readKV(o); err != nil {
+			t.Fatal(err)
+		}
+		if o.NATSConnection != nc {
+			t.Error("readKV() replaced the connection")
+		}
+
+		m := decodeMap(t, doc)
+		if topic := m["nats"].(map[string]any)["topic"]; topic != "from-kv" {
+			t.Errorf("nats.topic = %v, want from-kv", topic)
+		}
+		if verbose := m["notifier"].(map[string]any)["verbose"]; verbose != true {
+			t.Errorf("notifier.verbose = %v, want true", verbose)
+		}
+		if len(doc.Remotes) != 1 || doc.Remotes[0].(*KVSource).String() != "nats-kv://archiver/config.yaml" {
+			t.Fatalf("remotes = %v", doc.Remotes)
+		}
+		if doc.Remotes[0].(*KVSource).NATS != nc {
+			t.Error("readKV() didn't share the connection with the source")
+		}
+		// Values merged from the bucket are located in it
+		if pos := doc.Positions().Find("nats.topic"); pos.File != "nats-kv://archiver/config.yaml" {
+			t.Errorf("nats.topic at %s, want the bucket", pos)
+		}
+	})
+
+	t.Run("keeps its connection", func(t *testing.T) {
+		o := NewOptions()
+		if err := readConfig(t, config).readKV(o); err != nil {
+			t.Fatal(err)
+		}
+		if o.NATSConnection == nil || !o.NATSConnection.IsConnected() {
+			t.Fatal("readKV() didn't keep its connection")
+		}
+		o.NATSConnection.Close()
+	})
+
+	t.Run("closes its connection without connect", func(t *testing.T) {
+		o := NewOptions(WithoutConnect())
+		doc := readConfig(t, config)
+		if err := doc.readKV(o); err != nil {
+			t.Fatal(err)
+		}
+		if o.NATSConnection != nil || doc.Remotes[0].(*KVSource).NATS != nil {
+			t.Error("readKV() kept its connection")
+		}
+	})
+
+	t.Run("no bucket", func(t *testing.T) {
+		doc := readConfig(t, "nats:\n  host: "+s.ClientURL()+"\n")
+		o := NewOptions()
+		if err := doc.readKV(o); err != nil {
+			t.Fatal(err)
+		}
+		if o.NATSConnection != nil || len(doc.Remotes) > 0 {
+			t.Error("readKV() connected without a bucket")
+		}
+	})
+}
+
+func TestKVSourceWatch(t *testing.T) {
+	s, nc, kv := runJetStream(t, "nats:\n  topic: archiver\n")
+	for name, source := range map[string]*KVSource{
+		"own connection":    {Host: s.ClientURL(), Bucket: "archiver", Key: "config.yaml", Timeout: time.Second},
+		"shared connection": {Host: "nats://127.0.0.1:1", Bucket: "archiver", Key: "config.yaml", Timeout: time.Second, NATS: nc},
+	} {
+		t.Run(name, func(t *testing.T) {
+			testKVSourceWatch(t, source, kv)
+		})
+	}
+	if !nc.IsConnected() {
+		t.Error("Watch() closed the shared connection")
+	}
+}
+
+func testKVSourceWatch(t *testing.T, source *KVSource, kv nats.KeyValue) {
+	if _, err := kv.PutString("config.yaml", "nats:\n  topic: archiver\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changed := make(chan struct{}, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- source.Watch(ctx, func() { changed <- struct{}{} })
+	}()
+
+	// The current value isn't a change
+	select {
+	case <-changed:
+		t.Fatal("Watch() reported the current value as a change")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if _, err := kv.PutString("config.yaml", "nats:\n  topic: staging\n"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watch() didn't report the change")
+	}
+
+	// Other keys of the bucket are ignored
+	if _, err := kv.PutString("other.yaml", "nats: {}\n"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+		t.Error("Watch() reported a change to another key")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Watch() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watch() didn't return once ctx was done")
+	}
+}
+
+func TestKVSourceWatchUnreachable(t *testing.T) {
+	source := &KVSource{Host: "nats://127.0.0.1:1", Bucket: "archiver", Key: "config.yaml", Timeout: 100 * time.Millisecond}
+	err := source.Watch(context.Background(), func() {})
+	var derr *DependencyError
+	if !errors.As(err, &derr) || derr.Dependency != "nats" {
+		t.Errorf("Watch() = %v, want a nats DependencyError", err)
+	}
+}
+
+func TestReadKVCancelled(t *testing.T) {
+	// A server that accepts connections and never answers, and one whose
+	// JetStream API never answers
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	s := natstest.RunRandClientPortServer()
+	t.Cleanup(s.Shutdown)
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	if _, err := nc.Subscribe("$JS.API.>", func(*nats.Msg) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		host string
+		nc   *nats.Conn
+	}{
+		{"connect", "nats://" + l.Addr().String(), nil},
+		{"read", s.ClientURL(), nc},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(50*time.Millisecond, cancel)
+			doc := readConfig(t, "nats:\n  host: "+tt.host+"\n  timeout: 1m\n  config:\n    bucket: archiver\n")
+
+			start := time.Now()
+			err := doc.readKV(NewOptions(WithContext(ctx), WithNATSConnection(tt.nc)))
+			var derr *DependencyError
+			if !errors.As(err, &derr) || derr.Dependency != "nats" || derr.Timeout != 0 || !errors.Is(err, context.Canceled) {
+				t.Errorf("readKV() = %v, want a cancelled nats DependencyError", err)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("readKV() returned after %s, want it to give up once cancelled", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package misc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Names of the stages of Load, in the order they run.
+const (
+	StageSource      = "source"
+	StageProfile     = "profile"
+	StageInterpolate = "interpolate"
+	StageDecode      = "decode"
+	StageDefaults    = "defaults"
+	StageEnv         = "env"
+	StageSecrets     = "secrets"
+	StageValidate    = "validate"
+	StageConnect     = "connect"
+	StageAnnounce    = "announce"
+)
+
+// Validatable is a service config that can be loaded by Load. It is
+// implemented by the pointer to the Config of every service.
+type Validatable interface {
+	// Validate records the invalid values of the service section in v that
+	// the validate tags of its fields don't catch.
+	Validate(v *Validator)
+	// Connect connects to the external services of the service section,
+	// each within its timeout, until ctx is done. Failures are reported as
+	// a *DependencyError. NATS is connected to by Load.
+	Connect(ctx context.Context) error
+	// Disconnect closes what Connect opened, if anything. The NATS
+	// connection is left open, it is shared with the reloaded configs.
+	Disconnect() error
+	// NATSConfig returns the nats section of the config.
+	NATSConfig() *NATSConfig
+	// IsVerbose reports whether debug logging is enabled.
+	IsVerbose() bool
+	// ServiceName returns the name of the service, which is also the key
+	// of its section.
+	ServiceName() string
+}
+
+// Stage is a step of Load.
+type Stage struct {
+	Name string
+	Run  func(l *Loading) error
+}
+
+// Loading is the state of a config being loaded, shared by the stages of
+// Load.
+type Loading struct {
+	Options   *Options
+	Config    Validatable
+	Document  *Document
+	Positions Positions
+}
+
+type addedStage struct {
+	after string
+	stage Stage
+}
+
+// Stages returns the stages of Load: the default ones, with the stages
+// added by WithStage, and without connect and announce if WithoutConnect is
+// set.
+func (o *Options) Stages() []Stage {
+	defaults := []Stage{
+		{Name: StageSource, Run: sourceStage},
+		{Name: StageProfile, Run: profileStage},
+		{Name: StageInterpolate, Run: interpolateStage},
+		{Name: StageDecode, Run: decodeStage},
+		{Name: StageDefaults, Run: defaultsStage},
+		{Name: StageEnv, Run: envStage},
+		{Name: StageSecrets, Run: secretsStage},
+		{Name: StageValidate, Run: validateStage},
+		{Name: StageConnect, Run: connectStage},
+		{Name: StageAnnounce, Run: announceStage},
+	}
+
+	var stages []Stage
+	for _, s := range defaults {
+		if (s.Name == StageConnect || s.Name == StageAnnounce) && o.SkipConnect {
+			continue
+		}
+		stages = append(stages, s)
+		for _, added := range o.stages {
+			if added.after == s.Name {
+				stages = append(stages, added.stage)
+			}
+		}
+	}
+	return stages
+}
+
+// Load reads cfg, a pointer to an empty service config, runs it through
+// the stages of Load and returns it. Every invalid key is reported in a
+// single *ValidationError. If a stage fails, the connections opened by the
+// previous ones are closed.
+func Load[T Validatable](cfg T, opts ...Option) (T, error) {
+	l := &Loading{
+		Options: NewOptions(opts...),
+		Config:  cfg,
+	}
+	l.Options.configType = reflect.TypeOf(cfg)
+	given := l.Options.NATSConnection
+	for _, s := range l.Options.Stages() {
+		if err := s.Run(l); err != nil {
+			l.abort(given)
+			var zero T
+			return zero, err
+		}
+	}
+	return cfg, nil
+}
+
+// abort releases what the stages of a failed Load opened: the dependencies
+// of the config, and the NATS connection unless it is given, i.e. passed
+// with WithNATSConnection.
+func (l *Loading) abort(given *nats.Conn) {
+	if err := l.Config.Disconnect(); err != nil {
+		slog.Warn("unable to disconnect rejected config", slog.Any("err", err))
+	}
+	for _, nc := range []*nats.Conn{l.Options.NATSConnection, l.Config.NATSConfig().NatsConnection} {
+		if nc != nil && nc != given {
+			forgetAnnouncements(nc)
+			nc.Close()
+		}
+	}
+}
+
+// Watch returns a watcher that reloads cfg with Load whenever one of its
+// config files changes or SIGHUP is received. The NATS connection of cfg is
+// kept across reloads and used to read the config from JetStream, and
+// changes to nats.host and nats.topic are rejected before the new config
+// connects or is announced. The other dependencies
+// of a replaced config, e.g. its Docker client, are disconnected once the
+// subscribers are notified, and the ones of a rejected config right away.
+func Watch[T any, PT interface {
+	*T
+	Validatable
+}](cfg PT, opts ...Option) *Watcher[T] {
+	opts = opts[:len(opts):len(opts)]
+	read := func() (*Document, error) {
+		// Without a connection to reuse, the one made to read the config
+		// is closed right after
+		nc := cfg.NATSConfig().NatsConnection
+		return NewOptions(append(opts, WithNATSConnection(nc), WithoutConnect())...).ReadConfig()
+	}
+	w := NewWatcher(read, (*T)(cfg), func(old *T) (*T, *Document, error) {
+		current := PT(old).NATSConfig()
+		var doc *Document
+		next, err := Load(PT(new(T)), append(opts,
+			WithNATSConnection(current.NatsConnection),
+			WithStage(StageSource, Stage{Name: "watch", Run: func(l *Loading) error {
+				doc = l.Document
+				return nil
+			}}),
+			// Checked before connecting, a rejected config is never announced
+			WithStage(StageValidate, Stage{Name: "reload", Run: func(l *Loading) error {
+				return current.CheckReload(l.Config.NATSConfig())
+			}}),
+		)...)
+		if err != nil {
+			return nil, nil, err
+		}
+		return (*T)(next), doc, nil
+	})
+	w.retire = func(old *T) {
+		if err := PT(old).Disconnect(); err != nil {
+			slog.Warn("unable to disconnect replaced config", slog.Any("err", err))
+		}
+	}
+	return w
+}
+
+func sourceStage(l *Loading) error {
+	doc, err := l.Options.ReadConfig()
+	if err != nil {
+		return err
+	}
+	l.Document = doc
+	return nil
+}
+
+func profileStage(l *Loading) error {
+	return l.Document.ApplyProfile(l.Options.ConfigProfile())
+}
+
+// interpolateStage only interpolates the sections of the service config, an
+// unset variable used by another service isn't an error.
+func interpolateStage(l *Loading) error {
+	var sections []string
+	t := reflect.TypeOf(l.Config).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		if name, ok := FieldName(t.Field(i)); ok {
+			sections = append(sections, name)
+		}
+	}
+	if err := l.Document.Interpolate(sections...); err != nil {
+		return l.Document.Positions().Locate(err)
+	}
+	return nil
+}
+
+func decodeStage(l *Loading) error {
+	positions, err := l.Document.Decode(l.Config)
+	if err != nil {
+		return err
+	}
+	l.Positions = positions
+	for _, d := range l.Document.Deprecations {
+		slog.Warn(d.Message, slog.String("var", d.Key), slog.String("pos", d.Position.String()))
+	}
+	return nil
+}
+
+// defaultsStage allocates the sections missing from the config, so that
+// the later stages never see a nil section, and applies the default tags.
+func defaultsStage(l *Loading) error {
+	v := reflect.ValueOf(l.Config)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("unable to load config: %T is not a pointer to a struct", l.Config)
+	}
+	v = v.Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		if f.Kind() == reflect.Pointer && f.IsNil() && f.Type().Elem().Kind() == reflect.Struct && f.CanSet() {
+			f.Set(reflect.New(f.Type().Elem()))
+		}
+	}
+	return ApplyDefaults(l.Config)
+}
+
+func envStage(l *Loading) error {
+	return ApplyEnv(l.Config)
+}
+
+func secretsStage(l *Loading) error {
+	if err := ResolveSecrets(l.Config); err != nil {
+		return l.Positions.Locate(err)
+	}
+	return nil
+}
+
+func validateStage(l *Loading) error {
+	l.Options.SetVerbose(l.Config.IsVerbose())
+
+	v := NewValidator(l.Config)
+	v.CheckRules()
+	l.Config.Validate(v)
+	if err := v.Err(); err != nil {
+		return l.Positions.Locate(err)
+	}
+
+	if l.Config.IsVerbose() {
+		slog.Debug("loaded config", slog.Any("config", l.Config))
+	}
+	return nil
+}
+
+func connectStage(l *Loading) error {
+	ctx := l.Options.Context
+	if err := l.Config.Connect(ctx); err != nil {
+		return err
+	}
+	nats := l.Config.NATSConfig()
+	return ConnectDependency(ctx, "nats", nats.Timeout, func(ctx context.Context) error {
+		return nats.Load(ctx, l.Options.NATSConnection)
+	})
+}
+
+// announceStage announces the fingerprint of the config on NATS, see
+// Announce. As reloads load the config again, they are announced too.
+func announceStage(l *Loading) error {
+	return Announce(l.Config.NATSConfig().NatsConnection, NewAnnouncement(l.Config.ServiceName(), l.Config))
+}
@@ -0,0 +1,158 @@
+package misc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	natstest "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+)
+
+// openDependencies counts the dependencies of loadService configs that are
+// connected.
+var openDependencies int
+
+type loadService struct {
+	Service *struct {
+		Value string `yaml:"value"`
+	} `yaml:"service"`
+	NATS NATSConfig `yaml:"nats"`
+
+	connected bool
+}
+
+func (cfg *loadService) Validate(v *Validator) {
+	if cfg.Service.Value == "invalid" {
+		v.Invalid(&cfg.Service.Value)
+	}
+}
+
+func (cfg *loadService) Connect(context.Context) error {
+	cfg.connected = true
+	openDependencies++
+	return nil
+}
+
+func (cfg *loadService) Disconnect() error {
+	if cfg.connected {
+		cfg.connected = false
+		openDependencies--
+	}
+	return nil
+}
+
+func (cfg *loadService) NATSConfig() *NATSConfig { return &cfg.NATS }
+func (cfg *loadService) IsVerbose() bool         { return false }
+func (cfg *loadService) ServiceName() string     { return "service" }
+
+func TestLoadReleasesFailedConfigs(t *testing.T) {
+	s := natstest.RunRandClientPortServer()
+	t.Cleanup(s.Shutdown)
+	path := writeConfig(t, t.TempDir(), "config.yaml", "service:\n  value: a\nnats:\n  host: "+s.ClientURL()+"\n  topic: archiver\n")
+
+	openDependencies = 0
+	var cfg *loadService
+	_, err := Load(&loadService{}, WithConfigFile(path), WithStage(StageConnect, Stage{
+		Name: "fail",
+		Run: func(l *Loading) error {
+			cfg = l.Config.(*loadService)
+			return errors.New("failed")
+		},
+	}))
+	if err == nil {
+		t.Fatal("Load() = nil, want an error")
+	}
+	if openDependencies != 0 {
+		t.Errorf("%d dependencies left open", openDependencies)
+	}
+	if nc := cfg.NATS.NatsConnection; nc == nil || !nc.IsClosed() {
+		t.Error("NATS connection left open")
+	}
+}
+
+func TestWatchReleasesReplacedConfigs(t *testing.T) {
+	s := natstest.RunRandClientPortServer()
+	t.Cleanup(s.Shutdown)
+	config := "service:\n  value: %s\nnats:\n  host: " + s.ClientURL() + "\n  topic: %s\n"
+	path := writeConfig(t, t.TempDir(), "config.yaml", "")
+	write := func(value, topic string) {
+		t.Helper()
+		content := []byte(fmt.Sprintf(config, value, topic))
+		if err := os.WriteFile(path, content, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	openDependencies = 0
+	write("a", "archiver")
+	cfg, err := Load(&loadService{}, WithConfigFile(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc := cfg.NATS.NatsConnection
+	w := Watch(cfg, WithConfigFile(path))
+
+	write("b", "archiver")
+	if err := w.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.connected || !w.Config().connected || openDependencies != 1 {
+		t.Errorf("%d dependencies open after a reload, want only the ones of the new config", openDependencies)
+	}
+
+	// Rejected configs are released too
+	write("c", "staging")
+	if err := w.Reload(); !errors.Is(err, ErrNotReloadable) {
+		t.Errorf("Reload() = %v, want %v", err, ErrNotReloadable)
+	}
+	write("invalid", "archiver")
+	if err := w.Reload(); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Reload() = %v, want %v", err, ErrInvalid)
+	}
+	if w.Config().Service.Value != "b" || openDependencies != 1 {
+		t.Errorf("%d dependencies open after rejected reloads, want 1", openDependencies)
+	}
+
+	// The NATS connection is shared by every config
+	if w.Config().NATS.NatsConnection != nc || nc.IsClosed() {
+		t.Error("the NATS connection was replaced or closed")
+	}
+}
+
+func TestWatchDoesNotAnnounceRejectedConfigs(t *testing.T) {
+	s := natstest.RunRandClientPortServer()
+	t.Cleanup(s.Shutdown)
+	config := "service:\n  value: %s\nnats:\n  host: " + s.ClientURL() + "\n  topic: %s\n"
+	path := writeConfig(t, t.TempDir(), "config.yaml", fmt.Sprintf(config, "a", "archiver"))
+
+	cfg, err := Load(&loadService{}, WithConfigFile(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cfg.NATS.NatsConnection.Close)
+	w := Watch(cfg, WithConfigFile(path))
+
+	if err := os.WriteFile(path, []byte(fmt.Sprintf(config, "b", "staging")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Reload(); !errors.Is(err, ErrNotReloadable) {
+		t.Fatalf("Reload() = %v, want %v", err, ErrNotReloadable)
+	}
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	anns, err := Collect(nc, 500*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anns) != 1 || anns[0].Shared["nats.topic"] != "archiver" || anns[0].Fingerprint != Fingerprint(cfg) {
+		t.Errorf("announcements = %+v, want only the current config", anns)
+	}
+}
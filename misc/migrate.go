@@ -0,0 +1,141 @@
+package misc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigVersion is the version of the config file format written under
+// the top level version key. Files without it are version 0. It is only
+// bumped along with a migration from the previous version.
+const ConfigVersion = 0
+
+// Migration rewrites the top level map of a config file of version From
+// to version From+1. deprecate reports a key that was rewritten, e.g. with
+// RenameKey.
+type Migration struct {
+	From    int
+	Migrate func(root *yaml.Node, deprecate func(key *yaml.Node, path, message string)) error
+}
+
+// Migrations upgrade config files to ConfigVersion, one version at a time.
+// Every change of a key must come with a migration and a bump of
+// ConfigVersion, e.g. to rename restream_priority:
+//
+//	{
+//		From: 0,
+//		Migrate: func(root *yaml.Node, deprecate func(*yaml.Node, string, string)) error {
+//			return RenameKey(root, "notifier.platforms.*.restream_priority", "priority", deprecate)
+//		},
+//	}
+var Migrations []Migration
+
+// Deprecation is a key of an old config file rewritten by a migration.
+type Deprecation struct {
+	Position
+	Key     string
+	Message string
+}
+
+func (d Deprecation) String() string {
+	return fmt.Sprintf("%s: %s: %s", d.Position, d.Key, d.Message)
+}
+
+// Migrate upgrades root, the top level map of the config file file, to
+// ConfigVersion and sets its version key. Files that are up to date are
+// left as is. It returns the version the file had and the keys that were
+// rewritten.
+func Migrate(root *yaml.Node, file string) (from int, deprecations []Deprecation, err error) {
+	version := 0
+	versionNode := valueOf(root, "version")
+	if versionNode != nil {
+		version, err = strconv.Atoi(versionNode.Value)
+		if err != nil || version < 0 {
+			return 0, nil, fmt.Errorf("%s:%d:%d: invalid config version %q", file, versionNode.Line, versionNode.Column, versionNode.Value)
+		}
+	}
+	if version > ConfigVersion {
+		return version, nil, fmt.Errorf("config %s is version %d, newer than the supported version %d", file, version, ConfigVersion)
+	}
+	if version == ConfigVersion {
+		return version, nil, nil
+	}
+
+	deprecate := func(key *yaml.Node, path, message string) {
+		deprecations = append(deprecations, Deprecation{
+			Position: Position{File: file, Line: key.Line, Column: key.Column},
+			Key:      path,
+			Message:  message,
+		})
+	}
+	for v := version; v < ConfigVersion; v++ {
+		m, ok := findMigration(v)
+		if !ok {
+			return version, nil, fmt.Errorf("no migration of config version %d", v)
+		}
+		if err := m.Migrate(root, deprecate); err != nil {
+			return version, nil, fmt.Errorf("unable to migrate config %s from version %d: %w", file, v, err)
+		}
+	}
+
+	if versionNode == nil {
+		versionNode = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int"}
+		root.Content = append([]*yaml.Node{{Kind: yaml.ScalarNode, Value: "version"}, versionNode}, root.Content...)
+	}
+	versionNode.Value = strconv.Itoa(ConfigVersion)
+	return version, deprecations, nil
+}
+
+func findMigration(from int) (Migration, bool) {
+	for _, m := range Migrations {
+		if m.From == from {
+			return m, true
+		}
+	}
+	return Migration{}, false
+}
+
+// RenameKey renames every key at the dotted path in root to name and
+// reports them as deprecated. A * in path matches any key. It fails if the
+// new key is also set.
+func RenameKey(root *yaml.Node, path, name string, deprecate func(key *yaml.Node, path, message string)) error {
+	return renameKey(root, strings.Split(path, "."), nil, name, deprecate)
+}
+
+func renameKey(n *yaml.Node, path, parent []string, name string, deprecate func(*yaml.Node, string, string)) error {
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key := n.Content[i]
+		if path[0] != "*" && path[0] != key.Value {
+			continue
+		}
+		keyPath := append(parent[:len(parent):len(parent)], key.Value)
+		if len(path) > 1 {
+			if err := renameKey(n.Content[i+1], path[1:], keyPath, name, deprecate); err != nil {
+				return err
+			}
+			continue
+		}
+
+		newPath := strings.Join(append(parent[:len(parent):len(parent)], name), ".")
+		if keyIndex(n, name) >= 0 {
+			return fmt.Errorf("both %s and %s are set", strings.Join(keyPath, "."), newPath)
+		}
+		deprecate(key, strings.Join(keyPath, "."), "renamed to "+newPath)
+		key.Value = name
+	}
+	return nil
+}
+
+// valueOf returns the value of key in the mapping n, or nil.
+func valueOf(n *yaml.Node, key string) *yaml.Node {
+	if i := keyIndex(n, key); i >= 0 {
+		return n.Content[i+1]
+	}
+	return nil
+}
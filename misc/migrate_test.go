@@ -0,0 +1,105 @@
+package misc
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func parseRoot(t *testing.T, s string) *yaml.Node {
+	t.Helper()
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(s), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc.Content[0]
+}
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		err  string
+	}{
+		{name: "no version", yaml: "nats:\n  host: localhost\n"},
+		{name: "current version", yaml: "version: 0\nnats:\n  host: localhost\n"},
+		{name: "newer version", yaml: "version: 99\n", err: "newer than the supported version"},
+		{name: "invalid version", yaml: "version: latest\n", err: "invalid config version"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := parseRoot(t, tt.yaml)
+			before, _ := yaml.Marshal(root)
+
+			_, deprecations, err := Migrate(root, "config.yaml")
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Migrate() = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil || len(deprecations) > 0 {
+				t.Fatalf("Migrate() = %v, %v", deprecations, err)
+			}
+			// Up to date files are left as is
+			if after, _ := yaml.Marshal(root); string(after) != string(before) {
+				t.Errorf("Migrate() rewrote the file:\n%s", after)
+			}
+		})
+	}
+}
+
+func TestReadDocumentWithoutVersionHasNoDeprecations(t *testing.T) {
+	doc := readConfig(t, "nats:\n  host: localhost\n")
+	if len(doc.Deprecations) > 0 {
+		t.Errorf("deprecations = %v, want none", doc.Deprecations)
+	}
+}
+
+func TestRenameKey(t *testing.T) {
+	root := parseRoot(t, `
+notifier:
+  platforms:
+    kick:
+      restream_priority: 1
+    rumble:
+      restream_priority: 2
+    youtube:
+      channel: destiny
+`)
+	var deprecated []string
+	err := RenameKey(root, "notifier.platforms.*.restream_priority", "priority", func(key *yaml.Node, path, message string) {
+		deprecated = append(deprecated, path+": "+message)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"notifier.platforms.kick.restream_priority: renamed to notifier.platforms.kick.priority",
+		"notifier.platforms.rumble.restream_priority: renamed to notifier.platforms.rumble.priority",
+	}
+	if strings.Join(deprecated, "\n") != strings.Join(want, "\n") {
+		t.Errorf("deprecated:\n%s\nwant:\n%s", strings.Join(deprecated, "\n"), strings.Join(want, "\n"))
+	}
+	var m struct {
+		Notifier struct {
+			Platforms map[string]map[string]any
+		}
+	}
+	if err := root.Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Notifier.Platforms["kick"]["priority"] != 1 || m.Notifier.Platforms["rumble"]["priority"] != 2 {
+		t.Errorf("renamed platforms = %v", m.Notifier.Platforms)
+	}
+}
+
+func TestRenameKeyConflict(t *testing.T) {
+	root := parseRoot(t, "kick:\n  restream_priority: 1\n  priority: 2\n")
+	err := RenameKey(root, "kick.restream_priority", "priority", func(*yaml.Node, string, string) {})
+	if err == nil || !strings.Contains(err.Error(), "both kick.restream_priority and kick.priority are set") {
+		t.Errorf("RenameKey() = %v, want a conflict", err)
+	}
+}
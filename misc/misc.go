@@ -1,12 +1,15 @@
 package misc
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
 	"os"
 	"slices"
 	"strings"
 	"time"
 
+	"github.com/containrrr/shoutrrr"
 	"github.com/containrrr/shoutrrr/pkg/router"
 	"github.com/nats-io/nats.go"
 )
@@ -16,25 +19,124 @@ type Flags struct {
 }
 
 type NATSConfig struct {
-	Host           string `yaml:"host"`
-	Topic          string `yaml:"topic"`
-	NatsConnection *nats.Conn
+	Host           string        `yaml:"host" secret:"url" validate:"required" desc:"URL of the NATS server"`
+	Topic          string        `yaml:"topic" validate:"required" desc:"prefix of the NATS subjects the services communicate on"`
+	Config         KVConfig      `yaml:"config" desc:"JetStream key-value entry the rest of the config can be read from"`
+	Timeout        time.Duration `yaml:"timeout" default:"10s" desc:"time to wait for the NATS server when connecting"`
+	NatsConnection *nats.Conn    `yaml:"-"`
 }
 
-func (cfg *NATSConfig) Load() {
+// CheckReload returns an error if next changes a setting that can't be
+// changed without restarting the service.
+func (cfg *NATSConfig) CheckReload(next *NATSConfig) error {
+	var verr ValidationError
+	if next.Host != cfg.Host {
+		verr.Add("nats.host", ErrNotReloadable)
+	}
+	if next.Topic != cfg.Topic {
+		verr.Add("nats.topic", ErrNotReloadable)
+	}
+	if next.Config != cfg.Config {
+		verr.Add("nats.config", ErrNotReloadable)
+	}
+	return verr.Err()
+}
+
+// Load connects to the NATS server, or reuses nc if not nil. Connecting is
+// bounded by the deadline of ctx.
+func (cfg *NATSConfig) Load(ctx context.Context, nc *nats.Conn) error {
+	if nc != nil {
+		cfg.NatsConnection = nc
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	// Connect to NATS server
-	nc, err := nats.Connect(cfg.Host, nil, nats.PingInterval(20*time.Second), nats.MaxPingsOutstanding(5))
+	nc, err := dialNATS(ctx, cfg.Host, nats.PingInterval(20*time.Second), nats.MaxPingsOutstanding(5))
 	if err != nil {
-		slog.Error("unable to connect to NATS server", slog.Any("err", err))
-		os.Exit(1)
+		return err
 	}
 	cfg.NatsConnection = nc
+	return nil
+}
+
+// dialNATS connects to host within the deadline of ctx, and gives up once
+// ctx is done. A connection made after that is closed.
+func dialNATS(ctx context.Context, host string, opts ...nats.Option) (*nats.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		opts = append(opts, nats.Timeout(time.Until(deadline)))
+	}
+
+	type result struct {
+		nc  *nats.Conn
+		err error
+	}
+	connected := make(chan result, 1)
+	go func() {
+		nc, err := nats.Connect(host, opts...)
+		connected <- result{nc, err}
+	}()
+	select {
+	case r := <-connected:
+		if r.err != nil {
+			return nil, fmt.Errorf("unable to connect to NATS server: %w", r.err)
+		}
+		return r.nc, nil
+	case <-ctx.Done():
+		go func() {
+			if r := <-connected; r.nc != nil {
+				r.nc.Close()
+			}
+		}()
+		return nil, fmt.Errorf("unable to connect to NATS server: %w", ctx.Err())
+	}
+}
+
+// Close drains the NATS connection, letting the messages being handled
+// finish, and closes it once drained or when ctx is done. A connection
+// passed with WithNATSConnection is closed too.
+func (cfg *NATSConfig) Close(ctx context.Context) error {
+	nc := cfg.NatsConnection
+	if nc == nil || nc.IsClosed() {
+		return nil
+	}
+	forgetAnnouncements(nc)
+	if err := nc.Drain(); err != nil {
+		nc.Close()
+		return fmt.Errorf("unable to drain NATS connection: %w", err)
+	}
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for !nc.IsClosed() {
+		select {
+		case <-ctx.Done():
+			nc.Close()
+			return fmt.Errorf("unable to drain NATS connection: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
 }
 
 type Notifications struct {
-	Services   []string `yaml:"services"`
-	Conditions []string `yaml:"conditions"`
-	Sender     *router.ServiceRouter
+	Services   []string              `yaml:"services" secret:"url" desc:"shoutrrr URLs of the services notifications are sent to"`
+	Conditions []string              `yaml:"conditions" desc:"events that trigger a notification"`
+	Sender     *router.ServiceRouter `yaml:"-"`
+}
+
+// LogValue implements slog.LogValuer, masking the service URL tokens.
+func (n Notifications) LogValue() slog.Value {
+	return RedactedValue(n)
+}
+
+func (n Notifications) String() string {
+	return RedactedString(n)
 }
 
 func (n *Notifications) Enabled() bool {
@@ -45,6 +147,19 @@ func (n *Notifications) Condition(s string) bool {
 	return len(n.Services) > 0 && len(n.Conditions) > 0 && slices.Contains(n.Conditions, s)
 }
 
+// Load creates the notification sender if notifications are enabled.
+func (n *Notifications) Load() error {
+	if !n.Enabled() {
+		return nil
+	}
+	sender, err := shoutrrr.CreateSender(n.Services...)
+	if err != nil {
+		return fmt.Errorf("unable to create notification sender: %w", err)
+	}
+	n.Sender = sender
+	return nil
+}
+
 func SumArray(array []int) int {
 	result := 0
 	for _, v := range array {
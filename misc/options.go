@@ -0,0 +1,157 @@
+package misc
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"reflect"
+
+	"github.com/joho/godotenv"
+	"github.com/nats-io/nats.go"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Options control how a service config is loaded.
+type Options struct {
+	ConfigFile  string
+	Profile     string
+	Level       *slog.LevelVar
+	SkipConnect bool
+	// NATSConnection is reused instead of connecting to nats.host again.
+	NATSConnection *nats.Conn
+	// K8sClient reads the config set by $CONFIG_CONFIGMAP and
+	// $CONFIG_SECRET instead of the in-cluster client.
+	K8sClient kubernetes.Interface
+	// Context bounds reading remote config sources and connecting to
+	// external services, along with the timeout of each of them.
+	Context context.Context
+
+	stages []addedStage
+	// configType is the type of the config being loaded, see
+	// Document.renameAliases.
+	configType reflect.Type
+}
+
+type Option func(*Options)
+
+// WithConfigFile loads the config from path instead of $CONFIG.
+func WithConfigFile(path string) Option {
+	return func(o *Options) {
+		o.ConfigFile = path
+	}
+}
+
+// WithProfile selects the config profile name instead of $CONFIG_PROFILE.
+func WithProfile(name string) Option {
+	return func(o *Options) {
+		o.Profile = name
+	}
+}
+
+// WithLevel sets lvl to debug if the loaded config is verbose.
+func WithLevel(lvl *slog.LevelVar) Option {
+	return func(o *Options) {
+		o.Level = lvl
+	}
+}
+
+// WithoutConnect only validates the config, skipping connections to
+// NATS, Docker, K8s, SQLite and other external services.
+func WithoutConnect() Option {
+	return func(o *Options) {
+		o.SkipConnect = true
+	}
+}
+
+// WithNATSConnection reuses nc instead of opening a new NATS connection.
+func WithNATSConnection(nc *nats.Conn) Option {
+	return func(o *Options) {
+		o.NATSConnection = nc
+	}
+}
+
+// WithContext cancels loading the config when ctx is done.
+func WithContext(ctx context.Context) Option {
+	return func(o *Options) {
+		o.Context = ctx
+	}
+}
+
+// WithStage runs stage after the stage of Load named after.
+func WithStage(after string, stage Stage) Option {
+	return func(o *Options) {
+		o.stages = append(o.stages, addedStage{after: after, stage: stage})
+	}
+}
+
+// WithK8sClient reads the config ConfigMap and Secret with client.
+func WithK8sClient(client kubernetes.Interface) Option {
+	return func(o *Options) {
+		o.K8sClient = client
+	}
+}
+
+func NewOptions(opts ...Option) *Options {
+	o := &Options{Context: context.Background()}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// ConfigPath loads the .env file and returns the path of the config files.
+// It defaults to config.yaml, unless the config is read from the ConfigMap
+// set by $CONFIG_CONFIGMAP.
+func (o *Options) ConfigPath() string {
+	_ = godotenv.Load()
+
+	if o.ConfigFile == "" {
+		o.ConfigFile = os.Getenv("CONFIG")
+	}
+	if o.ConfigFile == "" && os.Getenv("CONFIG_CONFIGMAP") == "" {
+		o.ConfigFile = "config.yaml"
+	}
+	return o.ConfigFile
+}
+
+// ConfigProfile returns the name of the selected config profile, or "" to
+// use the base config.
+func (o *Options) ConfigProfile() string {
+	if o.Profile == "" {
+		o.Profile = os.Getenv("CONFIG_PROFILE")
+	}
+	return o.Profile
+}
+
+// ReadConfig reads and merges the config files, see ReadDocument, the K8s
+// ConfigMap and Secret, see K8sSourceFromEnv, and the config stored in the
+// JetStream key-value bucket set by nats.config, in that order.
+func (o *Options) ReadConfig() (*Document, error) {
+	doc, err := readDocument(o.ConfigPath(), false, o.configType)
+	if err != nil {
+		return nil, err
+	}
+	k8s, err := K8sSourceFromEnv(o.K8sClient)
+	if err != nil {
+		return nil, err
+	}
+	if k8s != nil {
+		o.K8sClient = k8s.Client
+		err := ConnectDependency(o.Context, "k8s", k8s.Timeout, func(ctx context.Context) error {
+			return k8s.read(ctx, doc)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := doc.readKV(o); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (o *Options) SetVerbose(verbose bool) {
+	if verbose && o.Level != nil {
+		o.Level.Set(slog.LevelDebug)
+	}
+}
@@ -0,0 +1,41 @@
+package misc
+
+import (
+	"fmt"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ApplyProfile removes the top level profiles map from the document and,
+// if name isn't empty, deep merges the profile name over the rest of the
+// document, like a file read after the others.
+func (d *Document) ApplyProfile(name string) error {
+	profiles := removeKey(d.Root, "profiles")
+	if name == "" {
+		return nil
+	}
+
+	var names []string
+	if profiles != nil && profiles.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(profiles.Content); i += 2 {
+			key, value := profiles.Content[i], profiles.Content[i+1]
+			if key.Value != name {
+				names = append(names, key.Value)
+				continue
+			}
+			if value.Kind != yaml.MappingNode {
+				return fmt.Errorf("%s:%d:%d: profile %s must be a map", d.files[key], key.Line, key.Column, name)
+			}
+			mergeNodes(d.Root, value)
+			clearTag(d.Root, AppendTag)
+			return nil
+		}
+	}
+
+	sort.Strings(names)
+	if suggestion := Closest(name, names); suggestion != "" {
+		return fmt.Errorf("unknown config profile %s, did you mean %s?", name, suggestion)
+	}
+	return fmt.Errorf("unknown config profile %s, defined profiles: %v", name, names)
+}
@@ -0,0 +1,106 @@
+package misc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const profileBase = `
+notifier:
+  channel: destiny
+  services:
+    - discord://a@b
+nats:
+  host: nats://prod:4222
+`
+
+func TestApplyProfile(t *testing.T) {
+	tests := []struct {
+		name     string
+		profiles string
+		profile  string
+		env      string
+		want     string
+		wantErr  string
+	}{
+		{
+			name:     "no profile",
+			profiles: "profiles:\n  dev:\n    nats:\n      host: nats://localhost:4222\n",
+			want:     profileBase,
+		},
+		{
+			name:     "merged over the base",
+			profiles: "profiles:\n  dev:\n    nats:\n      host: nats://localhost:4222\n",
+			profile:  "dev",
+			want:     "notifier:\n  channel: destiny\n  services: [discord://a@b]\nnats:\n  host: nats://localhost:4222\n",
+		},
+		{
+			name:     "append",
+			profiles: "profiles:\n  dev:\n    notifier:\n      services: !append\n        - telegram://c@d\n",
+			profile:  "dev",
+			want:     "notifier:\n  channel: destiny\n  services: [discord://a@b, telegram://c@d]\nnats:\n  host: nats://prod:4222\n",
+		},
+		{
+			name:     "selected by CONFIG_PROFILE",
+			profiles: "profiles:\n  dev:\n    notifier:\n      channel: dev\n",
+			env:      "dev",
+			want:     "notifier:\n  channel: dev\n  services: [discord://a@b]\nnats:\n  host: nats://prod:4222\n",
+		},
+		{
+			name:     "WithProfile over CONFIG_PROFILE",
+			profiles: "profiles:\n  dev:\n    notifier:\n      channel: dev\n  staging:\n    notifier:\n      channel: staging\n",
+			profile:  "staging",
+			env:      "dev",
+			want:     "notifier:\n  channel: staging\n  services: [discord://a@b]\nnats:\n  host: nats://prod:4222\n",
+		},
+		{
+			name:     "unknown profile",
+			profiles: "profiles:\n  staging: {}\n  dev: {}\n",
+			profile:  "stagin",
+			wantErr:  "unknown config profile stagin, did you mean staging?",
+		},
+		{
+			name:     "unknown profile without suggestion",
+			profiles: "profiles:\n  staging: {}\n  dev: {}\n",
+			profile:  "production",
+			wantErr:  "unknown config profile production, defined profiles: [dev staging]",
+		},
+		{
+			name:     "non-map profile",
+			profiles: "profiles:\n  dev: [nats]\n",
+			profile:  "dev",
+			wantErr:  "config.yaml:8:3: profile dev must be a map",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CONFIG_PROFILE", tt.env)
+			doc := readConfig(t, profileBase+tt.profiles)
+			err := doc.ApplyProfile(NewOptions(WithProfile(tt.profile)).ConfigProfile())
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasSuffix(err.Error(), tt.wantErr) {
+					t.Fatalf("ApplyProfile() = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got, want any
+			if err := doc.Root.Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if err := yaml.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("document = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package misc
+
+import (
+	"log/slog"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Redacted replaces secret values when a config is logged or printed.
+const Redacted = "REDACTED"
+
+// RedactURL masks the credentials, path and query values of a URL such as
+// a shoutrrr service or NATS URL, keeping its scheme and host.
+func RedactURL(s string) string {
+	if !strings.Contains(s, "://") {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return Redacted
+	}
+
+	redacted := u.Scheme + "://"
+	if u.User != nil {
+		redacted += Redacted + "@"
+	}
+	redacted += u.Host
+	if u.Path != "" && u.Path != "/" {
+		redacted += "/" + Redacted
+	}
+	if query := u.Query(); len(query) > 0 {
+		for k := range query {
+			query.Set(k, Redacted)
+		}
+		redacted += "?" + query.Encode()
+	}
+	return redacted
+}
+
+// RedactedNode returns cfg as a YAML tree with the fields tagged secret and
+// the values read by ResolveSecrets masked.
+func RedactedNode(cfg any) *yaml.Node {
+	return redactedNode(reflect.ValueOf(cfg), "")
+}
+
+// RedactedString returns cfg as YAML with the fields tagged secret masked.
+func RedactedString(cfg any) string {
+	var sb strings.Builder
+	enc := yaml.NewEncoder(&sb)
+	enc.SetIndent(2)
+	if err := enc.Encode(RedactedNode(cfg)); err != nil {
+		return err.Error()
+	}
+	return sb.String()
+}
+
+// RedactedValue returns cfg as a slog group with the fields tagged secret
+// masked.
+func RedactedValue(cfg any) slog.Value {
+	return nodeValue(RedactedNode(cfg))
+}
+
+func redactedNode(v reflect.Value, secret string) *yaml.Node {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		n := &yaml.Node{Kind: yaml.MappingNode}
+		for i := 0; i < v.NumField(); i++ {
+			sf := v.Type().Field(i)
+			name, ok := FieldName(sf)
+			if !ok {
+				continue
+			}
+			n.Content = append(n.Content,
+				&yaml.Node{Kind: yaml.ScalarNode, Value: name},
+				redactedNode(v.Field(i), SecretTag(sf)),
+			)
+		}
+		return n
+	case reflect.Slice:
+		n := &yaml.Node{Kind: yaml.SequenceNode}
+		for i := 0; i < v.Len(); i++ {
+			n.Content = append(n.Content, redactedNode(v.Index(i), secret))
+		}
+		return n
+	case reflect.Map:
+		n := &yaml.Node{Kind: yaml.MappingNode}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+		for _, k := range keys {
+			n.Content = append(n.Content,
+				&yaml.Node{Kind: yaml.ScalarNode, Value: k.String()},
+				redactedNode(v.MapIndex(k), secret),
+			)
+		}
+		return n
+	case reflect.String:
+		s := v.String()
+		switch {
+		case s == "":
+		case secret == "url":
+			s = RedactURL(s)
+		case secret != "", IsResolvedSecret(s):
+			s = Redacted
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s}
+	}
+
+	n := &yaml.Node{}
+	if err := n.Encode(v.Interface()); err != nil {
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: err.Error()}
+	}
+	return n
+}
+
+func nodeValue(n *yaml.Node) slog.Value {
+	switch n.Kind {
+	case yaml.MappingNode:
+		attrs := make([]slog.Attr, 0, len(n.Content)/2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			attrs = append(attrs, slog.Attr{Key: n.Content[i].Value, Value: nodeValue(n.Content[i+1])})
+		}
+		return slog.GroupValue(attrs...)
+	case yaml.SequenceNode:
+		items := make([]any, 0, len(n.Content))
+		for _, item := range n.Content {
+			items = append(items, nodeValue(item).Any())
+		}
+		return slog.AnyValue(items)
+	}
+
+	var v any
+	if err := n.Decode(&v); err != nil {
+		return slog.StringValue(n.Value)
+	}
+	return slog.AnyValue(v)
+}
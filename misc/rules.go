@@ -0,0 +1,38 @@
+package misc
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Rule is one of the comma separated rules of a `validate` tag, e.g.
+// required, oneof=volume bind or required_if=Method api.
+type Rule struct {
+	Name  string
+	Param string
+}
+
+// Rules returns the rules of the `validate` tag of field.
+func Rules(field reflect.StructField) []Rule {
+	tag := field.Tag.Get("validate")
+	if tag == "" {
+		return nil
+	}
+
+	var rules []Rule
+	for _, r := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(r, "=")
+		rules = append(rules, Rule{Name: name, Param: param})
+	}
+	return rules
+}
+
+// findRule returns the rule of rules named name.
+func findRule(rules []Rule, name string) (Rule, bool) {
+	for _, r := range rules {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return Rule{}, false
+}
@@ -0,0 +1,171 @@
+package misc
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Schema is a JSON Schema (draft 2020-12) document or subschema.
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties any                `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	Const                any                `json:"const,omitempty"`
+	Default              any                `json:"default,omitempty"`
+	Deprecated           bool               `json:"deprecated,omitempty"`
+	Minimum              *int64             `json:"minimum,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	If                   *Schema            `json:"if,omitempty"`
+	Then                 *Schema            `json:"then,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+}
+
+// durationPattern matches the durations parsed by time.ParseDuration, e.g.
+// 1m30s.
+const durationPattern = `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+
+// JSONSchema returns the schema of the sections of cfg, a pointer to a
+// service config, built from the yaml, default, validate and desc tags of
+// its fields. The required fields of a section with an enabled key are only
+// required when it is enabled.
+func JSONSchema(cfg any) *Schema {
+	return typeSchema(reflect.TypeOf(cfg))
+}
+
+func typeSchema(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return structSchema(t)
+	case reflect.Slice:
+		return &Schema{Type: "array", Items: typeSchema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: typeSchema(t.Elem())}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if t == durationType {
+			return &Schema{Type: "string", Pattern: durationPattern}
+		}
+		return &Schema{Type: "integer"}
+	}
+	return &Schema{Type: "string"}
+}
+
+func structSchema(t reflect.Type) *Schema {
+	s := &Schema{
+		Type:                 "object",
+		Properties:           map[string]*Schema{},
+		AdditionalProperties: false,
+	}
+	rules := &Schema{}
+	enabled := false
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name, ok := FieldName(sf)
+		if !ok {
+			continue
+		}
+		if name == "enabled" && sf.Type.Kind() == reflect.Bool {
+			enabled = true
+		}
+
+		fs := typeSchema(sf.Type)
+		fs.Description = sf.Tag.Get("desc")
+		if def, ok := sf.Tag.Lookup("default"); ok {
+			// The default of a map applies to its values
+			if items, ok := fs.AdditionalProperties.(*Schema); ok {
+				items.Default = tagValue(sf.Type.Elem(), def)
+			} else {
+				fs.Default = tagValue(sf.Type, def)
+			}
+		}
+
+		for _, r := range Rules(sf) {
+			switch r.Name {
+			case "required":
+				rules.Required = append(rules.Required, name)
+			case "oneof":
+				for _, v := range strings.Fields(r.Param) {
+					fs.Enum = append(fs.Enum, tagValue(sf.Type, v))
+				}
+			case "min":
+				if minimum, err := strconv.ParseInt(r.Param, 10, 64); err == nil {
+					fs.Minimum = &minimum
+				}
+			case "url":
+				fs.Format = "uri"
+			case "required_if":
+				other, value, _ := strings.Cut(r.Param, " ")
+				of, ok := t.FieldByName(other)
+				if !ok {
+					continue
+				}
+				otherName, _ := FieldName(of)
+				rules.AllOf = append(rules.AllOf, &Schema{
+					If: &Schema{
+						Properties: map[string]*Schema{otherName: {Const: tagValue(of.Type, value)}},
+						Required:   []string{otherName},
+					},
+					Then: &Schema{Required: []string{name}},
+				})
+			}
+		}
+
+		s.Properties[name] = fs
+		for _, alias := range Aliases(sf) {
+			as := *fs
+			as.Description = "deprecated, use " + name
+			as.Deprecated = true
+			s.Properties[alias] = &as
+		}
+	}
+
+	switch {
+	case len(rules.Required) == 0 && len(rules.AllOf) == 0:
+	case enabled:
+		s.If = &Schema{
+			Properties: map[string]*Schema{"enabled": {Const: true}},
+			Required:   []string{"enabled"},
+		}
+		s.Then = rules
+	default:
+		s.Required = rules.Required
+		s.AllOf = rules.AllOf
+	}
+	return s
+}
+
+// tagValue converts a value written in a struct tag to the type of the
+// field it belongs to. Lists are comma separated.
+func tagValue(t reflect.Type, s string) any {
+	switch t.Kind() {
+	case reflect.Slice:
+		items := []any{}
+		for _, item := range splitList(s) {
+			items = append(items, tagValue(t.Elem(), item))
+		}
+		return items
+	case reflect.Bool:
+		if b, err := parseBool(s); err == nil {
+			return b
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i
+		}
+	}
+	return s
+}
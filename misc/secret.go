@@ -0,0 +1,137 @@
+package misc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// DefaultSecretsDir is where Docker and K8s mount secrets referenced as
+// secret://name, unless $SECRETS_DIR is set.
+const DefaultSecretsDir = "/run/secrets"
+
+// resolvedSecrets holds the values read by ResolveSecrets, so that they are
+// masked when printed even if their field isn't tagged secret.
+var resolvedSecrets sync.Map
+
+// ResolveSecrets replaces the strings of the fields of cfg, a pointer to a
+// service config, that are written as a secret reference with the value it
+// points to:
+//
+//	file:///run/secrets/odysee_pw  the contents of the file
+//	env://RUMBLE_PASSWORD          the environment variable
+//	secret://odysee_pw             the file in $SECRETS_DIR or /run/secrets
+//
+// Fields whose values look like references but aren't, such as the SQLite
+// URI file:///data/vods.db, opt out with `secret:"-"`. Fields holding
+// credentials are tagged with `secret:"true"`, or `secret:"url"` for URLs
+// that embed them, so that they are masked when the config is printed;
+// resolved values are masked wherever they are.
+func ResolveSecrets(cfg any) error {
+	var verr ValidationError
+	_ = WalkFields(cfg, func(f Field) error {
+		if f.Struct.Tag.Get("secret") == "-" {
+			return nil
+		}
+		if err := resolveValue(f.Value); err != nil {
+			verr.Add(f.Key(), err)
+		}
+		return nil
+	})
+	return verr.Err()
+}
+
+func resolveValue(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.String:
+		s, err := resolveSecret(v.String())
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := resolveValue(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			s, err := resolveSecret(iter.Value().String())
+			if err != nil {
+				return err
+			}
+			v.SetMapIndex(iter.Key(), reflect.ValueOf(s).Convert(v.Type().Elem()))
+		}
+	}
+	return nil
+}
+
+// SecretTag returns the secret tag of a field, or "" if its value isn't
+// secret.
+func SecretTag(field reflect.StructField) string {
+	if tag := field.Tag.Get("secret"); tag != "-" {
+		return tag
+	}
+	return ""
+}
+
+// IsResolvedSecret reports whether s was read from a secret reference by
+// ResolveSecrets.
+func IsResolvedSecret(s string) bool {
+	_, ok := resolvedSecrets.Load(s)
+	return ok
+}
+
+func resolveSecret(s string) (string, error) {
+	scheme, ref, ok := strings.Cut(s, "://")
+	if !ok {
+		return s, nil
+	}
+
+	var value string
+	switch scheme {
+	case "env":
+		value, ok = os.LookupEnv(ref)
+		if !ok {
+			return "", fmt.Errorf("secret environment variable %s not set", ref)
+		}
+	case "file":
+		v, err := readSecret(ref)
+		if err != nil {
+			return "", err
+		}
+		value = v
+	case "secret":
+		dir := os.Getenv("SECRETS_DIR")
+		if dir == "" {
+			dir = DefaultSecretsDir
+		}
+		v, err := readSecret(filepath.Join(dir, ref))
+		if err != nil {
+			return "", err
+		}
+		value = v
+	default:
+		return s, nil
+	}
+	if value != "" {
+		resolvedSecrets.Store(value, struct{}{})
+	}
+	return value, nil
+}
+
+func readSecret(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to read secret: %w", err)
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
@@ -0,0 +1,105 @@
+package misc
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type secretConfig struct {
+	Service struct {
+		Password string   `yaml:"password" secret:"true"`
+		Services []string `yaml:"services" secret:"url"`
+		Token    string   `yaml:"token"`
+		URI      string   `yaml:"uri" secret:"-"`
+	} `yaml:"service"`
+}
+
+func TestResolveSecrets(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "odysee_pw"), []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SECRETS_DIR", dir)
+	t.Setenv("RUMBLE_PASSWORD", "from-env")
+
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"plain", "hunter2", "hunter2"},
+		{"env", "env://RUMBLE_PASSWORD", "from-env"},
+		{"file", "file://" + filepath.Join(dir, "odysee_pw"), "from-file"},
+		{"secret", "secret://odysee_pw", "from-file"},
+		{"other scheme", "https://example.com", "https://example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &secretConfig{}
+			cfg.Service.Password = tt.value
+			cfg.Service.Services = []string{tt.value}
+			cfg.Service.Token = tt.value
+			if err := ResolveSecrets(cfg); err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Service.Password != tt.want || cfg.Service.Services[0] != tt.want || cfg.Service.Token != tt.want {
+				t.Errorf("resolved %q to %q, %q and %q, want %q", tt.value, cfg.Service.Password, cfg.Service.Services[0], cfg.Service.Token, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveSecretsOptOut(t *testing.T) {
+	dir := t.TempDir()
+	db := filepath.Join(dir, "vods.db")
+	if err := os.WriteFile(db, []byte("SQLite format 3\x00"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &secretConfig{}
+	cfg.Service.URI = "file://" + db
+	if err := ResolveSecrets(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Service.URI != "file://"+db {
+		t.Errorf("uri = %q, want it unchanged", cfg.Service.URI)
+	}
+}
+
+func TestResolveSecretsErrors(t *testing.T) {
+	cfg := &secretConfig{}
+	cfg.Service.Password = "env://DGGARCHIVER_TEST_UNSET"
+	cfg.Service.Services = []string{"file:///nonexistent/secret"}
+
+	err := ResolveSecrets(cfg)
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 2 {
+		t.Fatalf("ResolveSecrets() = %v, want 2 errors", err)
+	}
+	if verr.Errors[0].Key != "service.password" || verr.Errors[1].Key != "service.services" {
+		t.Errorf("errors = %v, want service.password and service.services", err)
+	}
+}
+
+func TestResolvedSecretsAreRedacted(t *testing.T) {
+	t.Setenv("RUMBLE_PASSWORD", "from-env")
+	t.Setenv("DISCORD_URL", "discord://token@channel")
+	t.Setenv("API_TOKEN", "untagged-token")
+
+	cfg := &secretConfig{}
+	cfg.Service.Password = "env://RUMBLE_PASSWORD"
+	cfg.Service.Services = []string{"env://DISCORD_URL"}
+	cfg.Service.Token = "env://API_TOKEN"
+	if err := ResolveSecrets(cfg); err != nil {
+		t.Fatal(err)
+	}
+	s := RedactedString(cfg)
+	for _, secret := range []string{"from-env", "token@", "untagged-token"} {
+		if strings.Contains(s, secret) {
+			t.Errorf("redacted config contains %q:\n%s", secret, s)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package misc
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Closer is a resource released on shutdown, such as the Config of every
+// service.
+type Closer interface {
+	Close(ctx context.Context) error
+}
+
+// CloseFunc turns a function into a Closer, e.g. to wait for the work in
+// flight or to close the current config of a Watcher.
+type CloseFunc func(ctx context.Context) error
+
+func (f CloseFunc) Close(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Shutdown closes closers in order, giving them grace to finish. Every
+// closer is called even if the ones before it fail or time out.
+func Shutdown(grace time.Duration, closers ...Closer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	var errs []error
+	for _, c := range closers {
+		if err := c.Close(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// HandleShutdown returns a context cancelled on the first SIGINT or
+// SIGTERM, to stop taking new work. Closers are then closed in order with
+// Shutdown and the process exits, with status 1 if one of them failed. A
+// second signal exits immediately.
+func HandleShutdown(grace time.Duration, closers ...Closer) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		s := <-sig
+		slog.Info("shutting down", slog.String("signal", s.String()), slog.Duration("grace", grace))
+		cancel()
+
+		go func() {
+			s := <-sig
+			slog.Error("forced shutdown", slog.String("signal", s.String()))
+			os.Exit(1)
+		}()
+
+		if err := Shutdown(grace, closers...); err != nil {
+			slog.Error("unable to shut down cleanly", slog.Any("err", err))
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}()
+	return ctx
+}
@@ -0,0 +1,198 @@
+package misc
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"gopkg.in/yaml.v3"
+)
+
+// sopsValue matches a value encrypted by SOPS.
+var sopsValue = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.*),tag:(.*),type:(.*)\]$`)
+
+// IsSOPS reports whether root, the top level map of a config file, was
+// encrypted by SOPS.
+func IsSOPS(root *yaml.Node) bool {
+	meta := valueOf(root, "sops")
+	return meta != nil && meta.Kind == yaml.MappingNode && valueOf(meta, "mac") != nil
+}
+
+// ErrSOPSMAC is returned for SOPS files whose values don't match their
+// MAC, e.g. because they were edited without SOPS.
+var ErrSOPSMAC = errors.New("SOPS MAC mismatch")
+
+// decryptSOPS decrypts root, the top level map of file encrypted by SOPS
+// with age, in place and removes its SOPS metadata. The values are checked
+// against the MAC of the file, so that values can't be removed, added or
+// swapped without the data key. It returns the nodes it decrypted.
+func decryptSOPS(root *yaml.Node, file string, identities []age.Identity) ([]*yaml.Node, error) {
+	var meta struct {
+		Age []struct {
+			Recipient string `yaml:"recipient"`
+			Enc       string `yaml:"enc"`
+		} `yaml:"age"`
+		LastModified     string `yaml:"lastmodified"`
+		MAC              string `yaml:"mac"`
+		MACOnlyEncrypted bool   `yaml:"mac_only_encrypted"`
+	}
+	if err := removeKey(root, "sops").Decode(&meta); err != nil {
+		return nil, fmt.Errorf("invalid sops metadata in %s: %w", file, err)
+	}
+	if len(meta.Age) == 0 {
+		return nil, fmt.Errorf("unable to decrypt config %s: SOPS data key isn't encrypted with age", file)
+	}
+
+	var key []byte
+	err := errors.New("no age key")
+	for _, stanza := range meta.Age {
+		var r io.Reader
+		if r, err = age.Decrypt(armor.NewReader(strings.NewReader(stanza.Enc)), identities...); err != nil {
+			continue
+		}
+		if key, err = io.ReadAll(r); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt SOPS data key of %s: %w", file, err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SOPS data key in %s: %w", file, err)
+	}
+
+	// The MAC is the SHA-512 of the plaintext values in order
+	mac := sha512.New()
+	var decrypted []*yaml.Node
+	var verr ValidationError
+	walkScalars(root, nil, func(n *yaml.Node, path []string) {
+		ok, err := decryptSOPSValue(block, n, path)
+		if err != nil {
+			verr.Errors = append(verr.Errors, &FieldError{
+				Key:      strings.Join(path, "."),
+				Err:      err,
+				Position: Position{File: file, Line: n.Line, Column: n.Column},
+			})
+			return
+		}
+		if ok {
+			decrypted = append(decrypted, n)
+		}
+		if ok || !meta.MACOnlyEncrypted {
+			mac.Write(sopsBytes(n))
+		}
+	})
+	if err := verr.Err(); err != nil {
+		return nil, err
+	}
+
+	lastModified, err := time.Parse(time.RFC3339, meta.LastModified)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sops metadata in %s: lastmodified: %w", file, err)
+	}
+	want, _, err := sopsDecrypt(block, meta.MAC, []byte(lastModified.Format(time.RFC3339)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt SOPS MAC of %s: %w", file, err)
+	}
+	if got := fmt.Sprintf("%X", mac.Sum(nil)); got != want {
+		return nil, fmt.Errorf("unable to decrypt config %s: %w, the file was changed without SOPS", file, ErrSOPSMAC)
+	}
+	return decrypted, nil
+}
+
+// decryptSOPSValue decrypts n if it was encrypted by SOPS and reports
+// whether it did. Values are authenticated with the keys leading to them.
+func decryptSOPSValue(block cipher.Block, n *yaml.Node, path []string) (bool, error) {
+	if !sopsValue.MatchString(n.Value) {
+		return false, nil
+	}
+	value, typ, err := sopsDecrypt(block, n.Value, []byte(strings.Join(path, ":")+":"))
+	if err != nil {
+		return false, err
+	}
+
+	n.Value, n.Style = value, 0
+	switch typ {
+	case "str", "bytes":
+		n.Tag = "!!str"
+	case "int":
+		n.Tag = "!!int"
+	case "float":
+		n.Tag = "!!float"
+	case "bool":
+		b, err := strconv.ParseBool(n.Value)
+		if err != nil {
+			return false, fmt.Errorf("%w: %s", ErrInvalid, err)
+		}
+		n.Value, n.Tag = strconv.FormatBool(b), "!!bool"
+	default:
+		return false, fmt.Errorf("%w: unsupported SOPS value type %s", ErrInvalid, typ)
+	}
+	return true, nil
+}
+
+// sopsDecrypt decrypts s, a value encrypted by SOPS, authenticated with
+// additionalData, and returns it along with its type.
+func sopsDecrypt(block cipher.Block, s string, additionalData []byte) (value, typ string, err error) {
+	m := sopsValue.FindStringSubmatch(s)
+	if m == nil {
+		return "", "", fmt.Errorf("%w: not a SOPS encrypted value", ErrInvalid)
+	}
+	var parts [3][]byte
+	for i := range parts {
+		if parts[i], err = base64.StdEncoding.DecodeString(m[i+1]); err != nil {
+			return "", "", fmt.Errorf("%w: encrypted value isn't base64: %s", ErrInvalid, err)
+		}
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return "", "", fmt.Errorf("unable to decrypt value: %w", err)
+	}
+	plaintext, err := gcm.Open(nil, iv, append(data, tag...), additionalData)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to decrypt value: %w", err)
+	}
+	return string(plaintext), m[4], nil
+}
+
+// sopsBytes returns the bytes SOPS hashes for the value n into the MAC:
+// numbers and booleans are formatted the way SOPS does, null values
+// aren't hashed.
+func sopsBytes(n *yaml.Node) []byte {
+	switch n.ShortTag() {
+	case "!!null":
+		return nil
+	case "!!int":
+		var i int
+		if n.Decode(&i) == nil {
+			return []byte(strconv.Itoa(i))
+		}
+	case "!!float":
+		var f float64
+		if n.Decode(&f) == nil {
+			return []byte(strconv.FormatFloat(f, 'f', -1, 64))
+		}
+	case "!!bool":
+		var b bool
+		if n.Decode(&b) == nil {
+			if b {
+				return []byte("True")
+			}
+			return []byte("False")
+		}
+	}
+	return []byte(n.Value)
+}
@@ -0,0 +1,153 @@
+package misc
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testAgeKey is the age key testdata/sops.yaml and testdata/sops-partial.yaml
+// are encrypted to, with sops 3.8.1.
+const testAgeKey = "AGE-SECRET-KEY-168HAYFAT9ERCCX45DVAVMJMUMR4EWN4UNLNM7QXMTJV2F0Y7EK9QJG5KVA"
+
+// sopsPlain is what the SOPS test files decrypt to.
+var sopsPlain = map[string]any{
+	"nats": map[string]any{
+		"host":                "nats://localhost:4222",
+		"topic":               "archiver",
+		"timeout_unencrypted": "5s",
+	},
+	"uploader": map[string]any{
+		"verbose":          true,
+		"parallel_uploads": false,
+		"platforms": map[string]any{"odysee": map[string]any{
+			"enabled":  true,
+			"password": "pa$$word${",
+			"ratio":    1.5,
+		}},
+		"sqlite": map[string]any{"uri": nil},
+	},
+	"notifier": map[string]any{"platforms": map[string]any{"kick": map[string]any{
+		"refresh_time": 30,
+		"tags":         []any{"kick", "live"},
+	}}},
+}
+
+// copySOPS copies the SOPS test file name to a temporary directory, with
+// the replacements of replace applied.
+func copySOPS(t *testing.T, name string, replace ...string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writeConfig(t, t.TempDir(), name, strings.NewReplacer(replace...).Replace(string(data)))
+}
+
+func TestDecryptSOPS(t *testing.T) {
+	t.Setenv("CONFIG_AGE_KEY", testAgeKey)
+	for _, name := range []string{"sops.yaml", "sops-partial.yaml"} {
+		t.Run(name, func(t *testing.T) {
+			doc, err := ReadDocument(filepath.Join("testdata", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Decrypted values are taken verbatim
+			if err := doc.Interpolate(); err != nil {
+				t.Fatal(err)
+			}
+			if got := decodeMap(t, doc); !reflect.DeepEqual(got, sopsPlain) {
+				t.Errorf("decrypted %v, want %v", got, sopsPlain)
+			}
+		})
+	}
+}
+
+func TestDecryptSOPSTampered(t *testing.T) {
+	t.Setenv("CONFIG_AGE_KEY", testAgeKey)
+	tests := []struct {
+		name    string
+		file    string
+		replace []string
+		want    error
+	}{
+		{
+			name:    "changed unencrypted value",
+			file:    "sops.yaml",
+			replace: []string{"timeout_unencrypted: 5s", "timeout_unencrypted: 1s"},
+			want:    ErrSOPSMAC,
+		},
+		{
+			name:    "changed value of a partially encrypted file",
+			file:    "sops-partial.yaml",
+			replace: []string{"topic: archiver", "topic: staging"},
+			want:    ErrSOPSMAC,
+		},
+		{
+			name:    "added value",
+			file:    "sops-partial.yaml",
+			replace: []string{"    topic: archiver\n", "    topic: archiver\n    config:\n        bucket: evil\n"},
+			want:    ErrSOPSMAC,
+		},
+		{
+			name:    "removed value",
+			file:    "sops-partial.yaml",
+			replace: []string{"            ratio: 1.5\n", ""},
+			want:    ErrSOPSMAC,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := copySOPS(t, tt.file, tt.replace...)
+			if _, err := ReadDocument(path); !errors.Is(err, tt.want) {
+				t.Errorf("ReadDocument() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecryptSOPSMovedValue(t *testing.T) {
+	t.Setenv("CONFIG_AGE_KEY", testAgeKey)
+	data, err := os.ReadFile(filepath.Join("testdata", "sops.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Values are authenticated with their keys, so they can't be moved
+	var topic string
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "    topic: ") {
+			topic = line
+		}
+	}
+	path := copySOPS(t, "sops.yaml", topic+"\n", "", "    timeout_unencrypted:", strings.Replace(topic, "topic", "password", 1)+"\n    timeout_unencrypted:")
+
+	_, err = ReadDocument(path)
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Key != "nats.password" {
+		t.Errorf("ReadDocument() = %v, want nats.password to fail to decrypt", err)
+	}
+}
+
+func TestDecryptSOPSWithoutKey(t *testing.T) {
+	t.Setenv("CONFIG_AGE_KEY", "")
+	t.Setenv("CONFIG_AGE_KEY_FILE", "")
+	t.Setenv("SOPS_AGE_KEY", "")
+	t.Setenv("SOPS_AGE_KEY_FILE", "")
+	if _, err := ReadDocument(filepath.Join("testdata", "sops.yaml")); !errors.Is(err, ErrNoAgeKey) {
+		t.Errorf("ReadDocument() = %v, want %v", err, ErrNoAgeKey)
+	}
+}
+
+func TestConfigFilesKeepsSOPS(t *testing.T) {
+	// The encrypted version key of the file isn't read
+	files, err := ConfigFiles(filepath.Join("testdata", "sops.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("ConfigFiles() = %v", files)
+	}
+}
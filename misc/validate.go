@@ -0,0 +1,127 @@
+package misc
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// CheckRules checks the fields of the config against the rules of their
+// validate tag and records the broken ones:
+//
+//	required             the value is set
+//	required_if=F value  the value is set when the sibling field F is value
+//	oneof=a b            the value, if set, is one of the listed ones
+//	url                  the value, if set, is an absolute URL
+//	min=n                the number, or the length of the value, is at least n
+//
+// The fields of a section with an enabled key are only checked when it is
+// enabled.
+func (v *Validator) CheckRules() {
+	root := reflect.ValueOf(v.root).Elem()
+	v.checkStruct(root, nil)
+	_ = WalkFields(v.root, func(f Field) error {
+		if f.Value.Kind() != reflect.Struct {
+			return nil
+		}
+		if !enabled(f.Value) {
+			return errSkipFields
+		}
+		v.checkStruct(f.Value, f.Path)
+		return nil
+	})
+}
+
+func (v *Validator) checkStruct(s reflect.Value, path []string) {
+	t := s.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name, ok := FieldName(sf)
+		if !ok {
+			continue
+		}
+		key := strings.Join(append(path[:len(path):len(path)], name), ".")
+		for _, r := range Rules(sf) {
+			if err := checkRule(s, s.Field(i), r); err != nil {
+				v.errs.Add(key, err)
+				break
+			}
+		}
+	}
+}
+
+func checkRule(parent, value reflect.Value, r Rule) error {
+	switch r.Name {
+	case "required":
+		if value.IsZero() || isEmpty(value) {
+			return ErrNotSet
+		}
+	case "required_if":
+		other, want, _ := strings.Cut(r.Param, " ")
+		ov := parent.FieldByName(other)
+		if !ov.IsValid() {
+			return fmt.Errorf("unknown field %s in rule %s", other, r.Name)
+		}
+		if fmt.Sprint(ov.Interface()) == want && (value.IsZero() || isEmpty(value)) {
+			return ErrNotSet
+		}
+	case "oneof":
+		if value.IsZero() {
+			return nil
+		}
+		allowed := strings.Fields(r.Param)
+		for _, a := range allowed {
+			if fmt.Sprint(value.Interface()) == a {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: must be one of %s", ErrInvalid, strings.Join(allowed, ", "))
+	case "url":
+		if value.IsZero() {
+			return nil
+		}
+		if u, err := url.Parse(value.String()); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("%w: must be an absolute URL", ErrInvalid)
+		}
+	case "min":
+		minimum, err := strconv.ParseInt(r.Param, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid rule %s=%s: %w", r.Name, r.Param, err)
+		}
+		n := int64(0)
+		switch value.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = value.Int()
+		case reflect.String, reflect.Slice, reflect.Map:
+			n = int64(value.Len())
+		}
+		if n < minimum {
+			return fmt.Errorf("%w: must be at least %d", ErrInvalid, minimum)
+		}
+	default:
+		return fmt.Errorf("unknown rule %s", r.Name)
+	}
+	return nil
+}
+
+// enabled reports whether the section s is enabled, that is if it has no
+// enabled key or it is set to true.
+func enabled(s reflect.Value) bool {
+	t := s.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if name, ok := FieldName(t.Field(i)); ok && name == "enabled" && s.Field(i).Kind() == reflect.Bool {
+			return s.Field(i).Bool()
+		}
+	}
+	return true
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+package misc
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type rulesPlatform struct {
+	Enabled     bool     `yaml:"enabled"`
+	Method      string   `yaml:"method" validate:"required,oneof=scraper api"`
+	Credentials string   `yaml:"credentials" validate:"required_if=Method api"`
+	URL         string   `yaml:"url" validate:"url"`
+	RefreshTime int      `yaml:"refresh_time" validate:"required,min=1"`
+	Tags        []string `yaml:"tags" validate:"min=1"`
+}
+
+type rulesService struct {
+	Image    string        `yaml:"image" validate:"required"`
+	Platform rulesPlatform `yaml:"platform"`
+}
+
+type rulesConfig struct {
+	Service *rulesService `yaml:"service"`
+}
+
+func validRulesConfig() *rulesConfig {
+	return &rulesConfig{Service: &rulesService{
+		Image: "worker:main",
+		Platform: rulesPlatform{
+			Enabled:     true,
+			Method:      "scraper",
+			URL:         "https://kick.com",
+			RefreshTime: 5,
+			Tags:        []string{"kick"},
+		},
+	}}
+}
+
+func TestCheckRules(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(cfg *rulesConfig)
+		want   map[string]error
+	}{
+		{
+			name:   "valid",
+			modify: func(*rulesConfig) {},
+		},
+		{
+			name:   "required",
+			modify: func(cfg *rulesConfig) { cfg.Service.Image = "" },
+			want:   map[string]error{"service.image": ErrNotSet},
+		},
+		{
+			name:   "required list",
+			modify: func(cfg *rulesConfig) { cfg.Service.Platform.Tags = []string{} },
+			want:   map[string]error{"service.platform.tags": ErrInvalid},
+		},
+		{
+			name:   "oneof",
+			modify: func(cfg *rulesConfig) { cfg.Service.Platform.Method = "rss" },
+			want:   map[string]error{"service.platform.method": ErrInvalid},
+		},
+		{
+			name:   "required and oneof reports required",
+			modify: func(cfg *rulesConfig) { cfg.Service.Platform.Method = "" },
+			want:   map[string]error{"service.platform.method": ErrNotSet},
+		},
+		{
+			name:   "required_if met",
+			modify: func(cfg *rulesConfig) { cfg.Service.Platform.Method = "api" },
+			want:   map[string]error{"service.platform.credentials": ErrNotSet},
+		},
+		{
+			name: "required_if set",
+			modify: func(cfg *rulesConfig) {
+				cfg.Service.Platform.Method = "api"
+				cfg.Service.Platform.Credentials = "client_secret.json"
+			},
+		},
+		{
+			name:   "url",
+			modify: func(cfg *rulesConfig) { cfg.Service.Platform.URL = "kick.com" },
+			want:   map[string]error{"service.platform.url": ErrInvalid},
+		},
+		{
+			name:   "empty url",
+			modify: func(cfg *rulesConfig) { cfg.Service.Platform.URL = "" },
+		},
+		{
+			name:   "min",
+			modify: func(cfg *rulesConfig) { cfg.Service.Platform.RefreshTime = -1 },
+			want:   map[string]error{"service.platform.refresh_time": ErrInvalid},
+		},
+		{
+			name: "disabled section",
+			modify: func(cfg *rulesConfig) {
+				cfg.Service.Platform = rulesPlatform{Method: "rss"}
+			},
+		},
+		{
+			name: "every error",
+			modify: func(cfg *rulesConfig) {
+				cfg.Service.Image = ""
+				cfg.Service.Platform.Method = "api"
+				cfg.Service.Platform.RefreshTime = 0
+			},
+			want: map[string]error{
+				"service.image":                 ErrNotSet,
+				"service.platform.credentials":  ErrNotSet,
+				"service.platform.refresh_time": ErrNotSet,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validRulesConfig()
+			tt.modify(cfg)
+			v := NewValidator(cfg)
+			v.CheckRules()
+
+			got := map[string]error{}
+			var verr *ValidationError
+			if err := v.Err(); errors.As(err, &verr) {
+				for _, fe := range verr.Errors {
+					got[fe.Key] = fe.Err
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("CheckRules() reported %v, want %v", got, tt.want)
+			}
+			for key, want := range tt.want {
+				if !errors.Is(got[key], want) {
+					t.Errorf("%s: %v, want %v", key, got[key], want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidatorAdd(t *testing.T) {
+	cfg := validRulesConfig()
+	v := NewValidator(cfg)
+	v.NotSet(&cfg.Service.Platform.Credentials)
+	v.Invalid(&cfg.Service.Platform)
+
+	var verr *ValidationError
+	if !errors.As(v.Err(), &verr) {
+		t.Fatalf("Err() = %v", v.Err())
+	}
+	var keys []string
+	for _, fe := range verr.Errors {
+		keys = append(keys, fe.Key)
+	}
+	if want := []string{"service.platform.credentials", "service.platform"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys = %q, want %q", keys, want)
+	}
+}
+
+func TestRules(t *testing.T) {
+	field, _ := reflect.TypeOf(rulesPlatform{}).FieldByName("Method")
+	want := []Rule{{Name: "required"}, {Name: "oneof", Param: "scraper api"}}
+	if got := Rules(field); !reflect.DeepEqual(got, want) {
+		t.Errorf("Rules() = %v, want %v", got, want)
+	}
+}
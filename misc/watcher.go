@@ -0,0 +1,244 @@
+package misc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDelay collapses the bursts of events editors and K8s volume
+// updates generate into a single reload.
+const reloadDelay = 200 * time.Millisecond
+
+// A remote source that can't be watched is retried after remoteRetryDelay,
+// doubled after each failure up to maxRemoteRetryDelay.
+var (
+	remoteRetryDelay    = time.Second
+	maxRemoteRetryDelay = time.Minute
+)
+
+// Watcher holds the latest valid config of a service and reloads it when
+// one of its config files or remote sources changes or the process
+// receives SIGHUP. A config that fails to load is rejected and the
+// current one is kept.
+type Watcher[T any] struct {
+	read    func() (*Document, error)
+	load    func(old *T) (*T, *Document, error)
+	current atomic.Pointer[T]
+
+	mu          sync.Mutex
+	subscribers []func(old, new *T)
+	// retire releases the resources of a replaced config, once the
+	// subscribers are notified
+	retire func(old *T)
+
+	// files and dirs are the sources of the config, only used by Run
+	files map[string]bool
+	dirs  map[string]bool
+}
+
+// NewWatcher returns a Watcher starting with cfg. read returns the
+// document cfg was loaded from, to find the files to watch. load is called
+// with the current config and returns the new one and the document it was
+// loaded from.
+func NewWatcher[T any](read func() (*Document, error), cfg *T, load func(old *T) (*T, *Document, error)) *Watcher[T] {
+	w := &Watcher[T]{
+		read:  read,
+		load:  load,
+		files: map[string]bool{},
+		dirs:  map[string]bool{},
+	}
+	w.current.Store(cfg)
+	return w
+}
+
+// Config returns the current config.
+func (w *Watcher[T]) Config() *T {
+	return w.current.Load()
+}
+
+// Subscribe registers fn to be called after every successful reload.
+// Subscribers are called in order and must not call Reload. They must stop
+// using the old config before returning, its resources may be released.
+func (w *Watcher[T]) Subscribe(fn func(old, new *T)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.subscribers = append(w.subscribers, fn)
+}
+
+// Reload loads the config and, if it is valid, swaps it in and notifies
+// the subscribers.
+func (w *Watcher[T]) Reload() error {
+	_, err := w.swap()
+	return err
+}
+
+// swap implements Reload and returns the document the new config was
+// loaded from.
+func (w *Watcher[T]) swap() (*Document, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	old := w.current.Load()
+	cfg, doc, err := w.load(old)
+	if err != nil {
+		return nil, err
+	}
+	w.current.Store(cfg)
+
+	for _, fn := range w.subscribers {
+		fn(old, cfg)
+	}
+	if w.retire != nil {
+		w.retire(old)
+	}
+	return doc, nil
+}
+
+// Run watches the config files, remote sources and SIGHUP until ctx is
+// done. Remote sources are the ones of the config Run starts with.
+func (w *Watcher[T]) Run(ctx context.Context) error {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("unable to create config watcher: %w", err)
+	}
+	defer fw.Close()
+
+	doc, err := w.read()
+	if err != nil {
+		return err
+	}
+	if err := w.watch(fw, doc); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	changed := make(chan struct{}, 1)
+	for _, remote := range doc.Remotes {
+		go watchRemote(ctx, remote, func() {
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		})
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var delay <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-hup:
+			w.reload(fw)
+		case <-changed:
+			delay = time.After(reloadDelay)
+		case <-delay:
+			delay = nil
+			w.reload(fw)
+		case event, ok := <-fw.Events:
+			if !ok {
+				return nil
+			}
+			if w.affects(event) {
+				delay = time.After(reloadDelay)
+			}
+		case err, ok := <-fw.Errors:
+			if !ok {
+				return nil
+			}
+			slog.Warn("config watcher error", slog.Any("err", err))
+		}
+	}
+}
+
+// watchRemote watches remote until ctx is done, watching it again with
+// backoff whenever it fails or stops.
+func watchRemote(ctx context.Context, remote Remote, changed func()) {
+	delay := remoteRetryDelay
+	for {
+		started := time.Now()
+		err := remote.Watch(ctx, changed)
+		if ctx.Err() != nil {
+			return
+		}
+		// A watch that held up for a while failed for a new reason
+		if time.Since(started) > maxRemoteRetryDelay {
+			delay = remoteRetryDelay
+		}
+		slog.Warn("unable to watch config, retrying", slog.Any("source", remote), slog.Any("err", err), slog.Duration("delay", delay))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxRemoteRetryDelay {
+			delay = maxRemoteRetryDelay
+		}
+	}
+}
+
+// watch adds the directories of the config files of doc to fw.
+// Directories are watched rather than files, as editors and K8s replace
+// files instead of writing to them.
+func (w *Watcher[T]) watch(fw *fsnotify.Watcher, doc *Document) error {
+	for _, file := range doc.Files {
+		file = filepath.Clean(file)
+		w.files[file] = true
+		if err := fw.Add(filepath.Dir(file)); err != nil {
+			return fmt.Errorf("unable to watch config: %w", err)
+		}
+	}
+	for _, dir := range doc.Dirs {
+		dir = filepath.Clean(dir)
+		w.dirs[dir] = true
+		if err := fw.Add(dir); err != nil {
+			return fmt.Errorf("unable to watch config: %w", err)
+		}
+	}
+	return nil
+}
+
+func (w *Watcher[T]) affects(event fsnotify.Event) bool {
+	if event.Has(fsnotify.Chmod) {
+		return false
+	}
+	name := filepath.Clean(event.Name)
+	switch {
+	case w.files[name]:
+		return true
+	case w.dirs[filepath.Dir(name)] && isConfigFile(name):
+		return true
+	}
+	// K8s swaps the ..data symlink when a mounted ConfigMap changes
+	return strings.HasPrefix(filepath.Base(name), "..data")
+}
+
+func (w *Watcher[T]) reload(fw *fsnotify.Watcher) {
+	doc, err := w.swap()
+	if err != nil {
+		slog.Error("unable to reload config, keeping the current one", slog.Any("err", err))
+		return
+	}
+	slog.Info("config reloaded")
+
+	// The reloaded config may include new files
+	if err := w.watch(fw, doc); err != nil {
+		slog.Warn("unable to watch config", slog.Any("err", err))
+	}
+}
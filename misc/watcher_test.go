@@ -0,0 +1,152 @@
+package misc
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type watchConfig struct {
+	NATS NATSConfig `yaml:"nats"`
+}
+
+func TestWatcherSubscribe(t *testing.T) {
+	next := "staging"
+	w := NewWatcher(nil, &watchConfig{NATS: NATSConfig{Topic: "archiver"}}, func(old *watchConfig) (*watchConfig, *Document, error) {
+		if next == "" {
+			return nil, nil, errors.New("invalid config")
+		}
+		return &watchConfig{NATS: NATSConfig{Topic: next}}, nil, nil
+	})
+
+	var calls []string
+	for _, name := range []string{"first", "second"} {
+		name := name
+		w.Subscribe(func(old, new *watchConfig) {
+			calls = append(calls, name+": "+old.NATS.Topic+" -> "+new.NATS.Topic)
+		})
+	}
+
+	if err := w.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"first: archiver -> staging", "second: archiver -> staging"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("subscribers called with %q, want %q", calls, want)
+	}
+	if w.Config().NATS.Topic != "staging" {
+		t.Errorf("Config() = %+v, want the reloaded config", w.Config())
+	}
+
+	// Rejected configs aren't swapped in nor passed to the subscribers
+	next, calls = "", nil
+	if err := w.Reload(); err == nil {
+		t.Error("Reload() = nil, want an error")
+	}
+	if len(calls) > 0 || w.Config().NATS.Topic != "staging" {
+		t.Errorf("rejected reload called %q and swapped in %+v", calls, w.Config())
+	}
+}
+
+func TestWatcherRun(t *testing.T) {
+	path := writeConfig(t, t.TempDir(), "config.yaml", "nats:\n  topic: archiver\n")
+	load := func(*watchConfig) (*watchConfig, *Document, error) {
+		doc, err := ReadDocument(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		var cfg watchConfig
+		if _, err := doc.Decode(&cfg); err != nil {
+			return nil, nil, err
+		}
+		return &cfg, doc, nil
+	}
+	cfg, _, err := load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWatcher(func() (*Document, error) { return ReadDocument(path) }, cfg, load)
+	reloaded := make(chan string, 10)
+	w.Subscribe(func(_, new *watchConfig) { reloaded <- new.NATS.Topic })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- w.Run(ctx) }()
+
+	// Give Run the time to watch the directory, then keep editing the
+	// file until the change is seen
+	deadline := time.After(5 * time.Second)
+	for topic := ""; topic != "staging"; {
+		if err := os.WriteFile(path, []byte("nats:\n  topic: staging\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case topic = <-reloaded:
+		case <-time.After(reloadDelay * 3):
+		case <-deadline:
+			t.Fatal("Run() didn't reload the changed file")
+		}
+	}
+	if w.Config().NATS.Topic != "staging" {
+		t.Errorf("Config() = %+v, want the changed file", w.Config())
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() didn't return once ctx was done")
+	}
+}
+
+// flakyRemote fails to be watched fails times, then reports a change.
+type flakyRemote struct {
+	fails   int
+	watches int
+}
+
+func (r *flakyRemote) Watch(ctx context.Context, changed func()) error {
+	r.watches++
+	if r.watches <= r.fails {
+		return errors.New("unreachable")
+	}
+	changed()
+	<-ctx.Done()
+	return nil
+}
+
+func TestWatchRemoteRetries(t *testing.T) {
+	delay, maxDelay := remoteRetryDelay, maxRemoteRetryDelay
+	remoteRetryDelay, maxRemoteRetryDelay = time.Millisecond, 4*time.Millisecond
+	t.Cleanup(func() { remoteRetryDelay, maxRemoteRetryDelay = delay, maxDelay })
+
+	remote := &flakyRemote{fails: 5}
+	ctx, cancel := context.WithCancel(context.Background())
+	changed := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		watchRemote(ctx, remote, func() { changed <- struct{}{} })
+		close(done)
+	}()
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watchRemote() gave up on the failing remote")
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watchRemote() didn't return once ctx was done")
+	}
+	if remote.watches != remote.fails+1 {
+		t.Errorf("watched %d times, want %d", remote.watches, remote.fails+1)
+	}
+}
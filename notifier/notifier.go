@@ -3,80 +3,83 @@ package notifier
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"reflect"
 	"sort"
+	"time"
 
 	"github.com/DggHQ/dggarchiver-config/misc"
-	"github.com/containrrr/shoutrrr"
-	"github.com/joho/godotenv"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/option"
 	"google.golang.org/api/youtube/v3"
-	"gopkg.in/yaml.v2"
 )
 
 var (
+	ErrNoPlatforms       = errors.New("no platforms enabled")
 	ErrPriorityNotSet    = errors.New("priority not set for every enabled platform")
 	ErrPriorityNotUnique = errors.New("some priority is not a unique number from 1 to <num of enabled platforms>")
 )
 
 type Kick struct {
-	Enabled        bool
-	Method         string   `yaml:"method"`
-	URL            string   `yaml:"url"`
-	Authorization  string   `yaml:"authorization"`
-	Downloader     string   `yaml:"downloader"`
-	Quality        string   `yaml:"quality"`
-	Tags           []string `yaml:"tags"`
-	Priority       int      `yaml:"restream_priority"`
-	Channel        string   `yaml:"channel"`
-	HealthCheck    string   `yaml:"healthcheck"`
-	RefreshTime    int      `yaml:"refresh_time"`
-	ProxyURL       string   `yaml:"proxy_url"`
-	WorkerProxyURL string   `yaml:"worker_proxy_url"`
+	Enabled        bool     `desc:"watch the channel for livestreams"`
+	Method         string   `yaml:"method" default:"scraper" desc:"how livestreams are detected, only scraper is supported and other values are ignored"`
+	URL            string   `yaml:"url" default:"https://kick.com" validate:"url" desc:"base URL of Kick"`
+	Authorization  string   `yaml:"authorization" secret:"true" desc:"authorization header sent to Kick"`
+	Downloader     string   `yaml:"downloader" default:"yt-dlp" validate:"oneof=yt-dlp N_m3u8DL-RE" desc:"downloader used by the worker"`
+	Quality        string   `yaml:"quality" validate:"required" desc:"stream quality passed to the downloader"`
+	Tags           []string `yaml:"tags" desc:"tags attached to archived streams"`
+	Priority       int      `yaml:"restream_priority" desc:"priority of the platform when a stream is restreamed, 1 being the highest"`
+	Channel        string   `yaml:"channel" validate:"required" desc:"channel to watch"`
+	HealthCheck    string   `yaml:"healthcheck" desc:"URL pinged after every check"`
+	RefreshTime    int      `yaml:"refresh_time" alias:"refresh_interval" validate:"required,min=1" desc:"interval between checks"`
+	ProxyURL       string   `yaml:"proxy_url" validate:"url" desc:"proxy used to check the channel"`
+	WorkerProxyURL string   `yaml:"worker_proxy_url" validate:"url" desc:"proxy used by the worker to download the stream"`
 }
 
 type Rumble struct {
-	Enabled        bool
-	Method         string   `yaml:"method"`
-	Downloader     string   `yaml:"downloader"`
-	Quality        string   `yaml:"quality"`
-	Tags           []string `yaml:"tags"`
-	Priority       int      `yaml:"restream_priority"`
-	Channel        string   `yaml:"channel"`
-	HealthCheck    string   `yaml:"healthcheck"`
-	RefreshTime    int      `yaml:"refresh_time"`
-	ProxyURL       string   `yaml:"proxy_url"`
-	WorkerProxyURL string   `yaml:"worker_proxy_url"`
+	Enabled        bool     `desc:"watch the channel for livestreams"`
+	Method         string   `yaml:"method" default:"scraper" desc:"how livestreams are detected, only scraper is supported and other values are ignored"`
+	Downloader     string   `yaml:"downloader" default:"yt-dlp" validate:"oneof=yt-dlp N_m3u8DL-RE" desc:"downloader used by the worker"`
+	Quality        string   `yaml:"quality" validate:"required" desc:"stream quality passed to the downloader"`
+	Tags           []string `yaml:"tags" desc:"tags attached to archived streams"`
+	Priority       int      `yaml:"restream_priority" desc:"priority of the platform when a stream is restreamed, 1 being the highest"`
+	Channel        string   `yaml:"channel" validate:"required" desc:"channel to watch"`
+	HealthCheck    string   `yaml:"healthcheck" desc:"URL pinged after every check"`
+	RefreshTime    int      `yaml:"refresh_time" alias:"refresh_interval" validate:"required,min=1" desc:"interval between checks"`
+	ProxyURL       string   `yaml:"proxy_url" validate:"url" desc:"proxy used to check the channel"`
+	WorkerProxyURL string   `yaml:"worker_proxy_url" validate:"url" desc:"proxy used by the worker to download the stream"`
 }
 
 type YouTube struct {
-	Enabled        bool
-	Method         string   `yaml:"method"`
-	Downloader     string   `yaml:"downloader"`
-	Quality        string   `yaml:"quality"`
-	Tags           []string `yaml:"tags"`
-	Priority       int      `yaml:"restream_priority"`
-	Channel        string   `yaml:"channel"`
-	HealthCheck    string   `yaml:"healthcheck"`
-	RefreshTime    int      `yaml:"refresh_time"`
-	GoogleCred     string   `yaml:"google_credentials"`
-	ProxyURL       string   `yaml:"proxy_url"`
-	WorkerProxyURL string   `yaml:"worker_proxy_url"`
-	Service        *youtube.Service
+	Enabled        bool             `desc:"watch the channel for livestreams"`
+	Method         string           `yaml:"method" validate:"required,oneof=scraper api" desc:"how livestreams are detected"`
+	Downloader     string           `yaml:"downloader" default:"yt-dlp" validate:"oneof=yt-dlp yt-dlp/piped ytarchive" desc:"downloader used by the worker"`
+	Quality        string           `yaml:"quality" validate:"required" desc:"stream quality passed to the downloader"`
+	Tags           []string         `yaml:"tags" desc:"tags attached to archived streams"`
+	Priority       int              `yaml:"restream_priority" desc:"priority of the platform when a stream is restreamed, 1 being the highest"`
+	Channel        string           `yaml:"channel" validate:"required" desc:"channel to watch"`
+	HealthCheck    string           `yaml:"healthcheck" desc:"URL pinged after every check"`
+	RefreshTime    int              `yaml:"refresh_time" alias:"refresh_interval" validate:"required,min=1" desc:"interval between checks"`
+	GoogleCred     string           `yaml:"google_credentials" validate:"required_if=Method api" desc:"Google service account key file, required by the api method"`
+	ProxyURL       string           `yaml:"proxy_url" validate:"url" desc:"proxy used to check the channel"`
+	WorkerProxyURL string           `yaml:"worker_proxy_url" validate:"url" desc:"proxy used by the worker to download the stream"`
+	Timeout        time.Duration    `yaml:"timeout" default:"10s" desc:"time to wait for Google to authorize the api method"`
+	Service        *youtube.Service `yaml:"-"`
 }
 
 type Notifier struct {
-	Verbose   bool
+	Verbose   bool `desc:"enable debug logging"`
 	Platforms struct {
-		YouTube YouTube `yaml:"youtube"`
-		Rumble  Rumble  `yaml:"rumble"`
-		Kick    Kick    `yaml:"kick"`
-	}
-	Notifications misc.Notifications `yaml:"notifications"`
+		YouTube YouTube `yaml:"youtube" desc:"YouTube channel"`
+		Rumble  Rumble  `yaml:"rumble" desc:"Rumble channel"`
+		Kick    Kick    `yaml:"kick" desc:"Kick channel"`
+	} `alias:"platform" desc:"platforms watched for livestreams"`
+	Notifications misc.Notifications `yaml:"notifications" desc:"notifications sent by the notifier"`
+	ShutdownGrace time.Duration      `yaml:"shutdown_grace" default:"30s" desc:"time given to the service to shut down on SIGINT or SIGTERM"`
 }
 
 type Config struct {
@@ -84,52 +87,80 @@ type Config struct {
 	NATS      misc.NATSConfig `yaml:"nats"`
 }
 
+// LogValue implements slog.LogValuer, masking passwords, tokens and the
+// credentials in service URLs.
+func (cfg Config) LogValue() slog.Value {
+	return misc.RedactedValue(cfg)
+}
+
+func (cfg Config) String() string {
+	return misc.RedactedString(cfg)
+}
+
+// New loads the notifier config, logging the error and exiting on failure.
 func New() *Config {
-	var (
-		err error
-		cfg = Config{}
-		lvl slog.LevelVar
-	)
+	var lvl slog.LevelVar
 
 	misc.SetupSlog(&lvl)
 
-	_ = godotenv.Load()
-
-	configFile := os.Getenv("CONFIG")
-	if configFile == "" {
-		configFile = "config.yaml"
-	}
-	configBytes, err := os.ReadFile(configFile)
+	cfg, err := Load(misc.WithLevel(&lvl))
 	if err != nil {
-		slog.Error("unable to load config", slog.Any("err", err))
-		os.Exit(1)
+		misc.LogFatal(err)
 	}
 
-	err = yaml.Unmarshal(configBytes, &cfg)
-	if err != nil {
-		slog.Error("unable to unmarshall config yaml", slog.Any("err", err))
-		os.Exit(1)
-	}
+	return cfg
+}
 
-	if cfg.Notifier.Verbose {
-		lvl.Set(slog.LevelDebug)
-	}
+// Load reads and validates the notifier config and connects to the
+// configured services, see misc.Load.
+func Load(opts ...misc.Option) (*Config, error) {
+	return misc.Load(&Config{}, opts...)
+}
 
-	cfg.Notifier.initialize()
+// Watch returns a watcher that reloads cfg whenever one of its config files
+// changes or SIGHUP is received, see misc.Watch.
+func Watch(cfg *Config, opts ...misc.Option) *misc.Watcher[Config] {
+	return misc.Watch(cfg, opts...)
+}
 
-	// NATS Host Name or IP
-	if cfg.NATS.Host == "" {
-		slog.Error("config variable not set", slog.String("var", "nats:host"))
-		os.Exit(1)
-	}
-	// NATS Topic Name
-	if cfg.NATS.Topic == "" {
-		slog.Error("config variable not set", slog.String("var", "nats:topic"))
-		os.Exit(1)
+// Validate implements misc.Validatable.
+func (cfg *Config) Validate(v *misc.Validator) {
+	cfg.Notifier.validate(v)
+}
+
+// Connect implements misc.Validatable.
+func (cfg *Config) Connect(ctx context.Context) error {
+	return cfg.Notifier.connect(ctx)
+}
+
+// Disconnect implements misc.Validatable, it drops the YouTube client.
+// Its HTTP connections are idle ones of the shared default transport.
+func (cfg *Config) Disconnect() error {
+	if cfg.Notifier != nil {
+		cfg.Platforms.YouTube.Service = nil
 	}
-	cfg.NATS.Load()
+	return nil
+}
+
+// Close drains the NATS connection. The YouTube and notification clients
+// hold no connection of their own.
+func (cfg *Config) Close(ctx context.Context) error {
+	return cfg.NATS.Close(ctx)
+}
+
+// NATSConfig implements misc.Validatable.
+func (cfg *Config) NATSConfig() *misc.NATSConfig {
+	return &cfg.NATS
+}
 
-	return &cfg
+// ServiceName implements misc.Validatable.
+func (cfg *Config) ServiceName() string {
+	return "notifier"
+}
+
+// IsVerbose implements misc.Validatable.
+func (cfg *Config) IsVerbose() bool {
+	return cfg.Notifier.Verbose
 }
 
 func (notifier *Notifier) validatePlatforms() bool {
@@ -172,128 +203,62 @@ func (notifier *Notifier) validatePriority() error {
 	return nil
 }
 
-func (notifier *Notifier) initialize() {
+func (notifier *Notifier) validate(v *misc.Validator) {
 	if !notifier.validatePlatforms() {
-		slog.Error("no platforms enabled")
-		os.Exit(1)
+		v.Add(&notifier.Platforms, ErrNoPlatforms)
 	}
 
 	if err := notifier.validatePriority(); err != nil {
-		slog.Error("unable to validate platform priority", slog.Any("err", err))
-		os.Exit(1)
+		v.Add(&notifier.Platforms, err)
 	}
 
-	// YouTube
-	if notifier.Platforms.YouTube.Enabled {
-		if notifier.Platforms.YouTube.Channel == "" {
-			slog.Error("config variable not set", slog.String("var", "notifier:platform:youtube:channel"))
-			os.Exit(1)
-		}
-		if notifier.Platforms.YouTube.RefreshTime == 0 {
-			slog.Error("config variable not set", slog.String("var", "notifier:platform:youtube:refresh_time"))
-			os.Exit(1)
-		}
-		if notifier.Platforms.YouTube.Downloader == "" {
-			notifier.Platforms.YouTube.Downloader = "yt-dlp"
-		}
-		if notifier.Platforms.YouTube.Quality == "" {
-			slog.Error("config variable not set", slog.String("var", "notifier:platform:youtube:quality"))
-			os.Exit(1)
-		}
-
-		switch notifier.Platforms.YouTube.Method {
-		case "scraper":
-		case "api":
-			if notifier.Platforms.YouTube.GoogleCred == "" {
-				slog.Error("config variable not set", slog.String("var", "notifier:platform:youtube:google_credentials"))
-				os.Exit(1)
-			}
-			notifier.createGoogleClients()
-		case "":
-			slog.Error("config variable not set", slog.String("var", "notifier:platform:youtube:method"))
-			os.Exit(1)
-		default:
-			slog.Error("invalid config variable", slog.String("var", "notifier:platform:youtube:method"))
-			os.Exit(1)
-		}
-	}
-
-	// Rumble
-	if notifier.Platforms.Rumble.Enabled {
-		if notifier.Platforms.Rumble.Channel == "" {
-			slog.Error("config variable not set", slog.String("var", "notifier:platform:rumble:channel"))
-			os.Exit(1)
-		}
-		if notifier.Platforms.Rumble.RefreshTime == 0 {
-			slog.Error("config variable not set", slog.String("var", "notifier:platform:rumble:refresh_time"))
-			os.Exit(1)
-		}
-		if notifier.Platforms.Rumble.Downloader == "" {
-			notifier.Platforms.Rumble.Downloader = "yt-dlp"
-		}
-		if notifier.Platforms.Rumble.Quality == "" {
-			slog.Error("config variable not set", slog.String("var", "notifier:platform:rumble:quality"))
-			os.Exit(1)
-		}
+	// Kick and Rumble are always scraped, whatever method is set
+	forceScraper("notifier.platforms.kick.method", &notifier.Platforms.Kick.Method, notifier.Platforms.Kick.Enabled)
+	forceScraper("notifier.platforms.rumble.method", &notifier.Platforms.Rumble.Method, notifier.Platforms.Rumble.Enabled)
 
-		notifier.Platforms.Rumble.Method = "scraper"
+	// Notifications
+	if err := notifier.Notifications.Load(); err != nil {
+		v.Add(&notifier.Notifications.Services, err)
 	}
+}
 
-	// Kick
-	if notifier.Platforms.Kick.Enabled {
-		if notifier.Platforms.Kick.URL == "" {
-			notifier.Platforms.Kick.URL = "https://kick.com"
-		}
-		if notifier.Platforms.Kick.Channel == "" {
-			slog.Error("config variable not set", slog.String("var", "notifier:platform:kick:channel"))
-			os.Exit(1)
-		}
-		if notifier.Platforms.Kick.RefreshTime == 0 {
-			slog.Error("config variable not set", slog.String("var", "notifier:platform:kick:refresh_time"))
-			os.Exit(1)
-		}
-		if notifier.Platforms.Kick.Downloader == "" {
-			notifier.Platforms.Kick.Downloader = "yt-dlp"
-		}
-		if notifier.Platforms.Kick.Quality == "" {
-			slog.Error("config variable not set", slog.String("var", "notifier:platform:kick:quality"))
-			os.Exit(1)
-		}
-
-		notifier.Platforms.Kick.Method = "scraper"
+// forceScraper sets method to scraper, warning about any other method set
+// for an enabled platform.
+func forceScraper(key string, method *string, enabled bool) {
+	if *method != "scraper" && enabled {
+		slog.Warn("only the scraper method is supported, ignoring method", slog.String("var", key), slog.String("method", *method))
 	}
+	*method = "scraper"
+}
 
-	// Notifications
-	if notifier.Notifications.Enabled() {
-		var err error
-		notifier.Notifications.Sender, err = shoutrrr.CreateSender(notifier.Notifications.Services...)
-		if err != nil {
-			slog.Error("unable to create notification sender", slog.Any("err", err))
-			os.Exit(1)
-		}
+func (notifier *Notifier) connect(ctx context.Context) error {
+	if notifier.Platforms.YouTube.Enabled && notifier.Platforms.YouTube.Method == "api" {
+		return misc.ConnectDependency(ctx, "google", notifier.Platforms.YouTube.Timeout, notifier.createGoogleClients)
 	}
+	return nil
 }
 
-func (notifier *Notifier) createGoogleClients() {
-	ctx := context.Background()
-
+func (notifier *Notifier) createGoogleClients(ctx context.Context) error {
 	credpath := filepath.Join(".", notifier.Platforms.YouTube.GoogleCred)
 	b, err := os.ReadFile(credpath)
 	if err != nil {
-		slog.Error("unable to read client secret file", slog.Any("err", err))
-		os.Exit(1)
+		return fmt.Errorf("unable to read client secret file: %w", err)
 	}
 
 	googleCfg, err := google.JWTConfigFromJSON(b, "https://www.googleapis.com/auth/youtube.readonly")
 	if err != nil {
-		slog.Error("unable to parse client secret file", slog.Any("err", err))
-		os.Exit(1)
+		return fmt.Errorf("unable to parse client secret file: %w", err)
 	}
-	client := googleCfg.Client(ctx)
+	// The first token is fetched within ctx, the client outlives it
+	token, err := googleCfg.TokenSource(ctx).Token()
+	if err != nil {
+		return fmt.Errorf("unable to authorize youtube client: %w", err)
+	}
+	client := oauth2.NewClient(context.Background(), oauth2.ReuseTokenSource(token, googleCfg.TokenSource(context.Background())))
 
 	notifier.Platforms.YouTube.Service, err = youtube.NewService(ctx, option.WithHTTPClient(client))
 	if err != nil {
-		slog.Error("unable to retrieve youtube client", slog.Any("err", err))
-		os.Exit(1)
+		return fmt.Errorf("unable to retrieve youtube client: %w", err)
 	}
+	return nil
 }
@@ -0,0 +1,104 @@
+package notifier
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DggHQ/dggarchiver-config/misc"
+)
+
+func TestLoadForcesScraper(t *testing.T) {
+	for _, method := range []string{"", "scraper", "api"} {
+		t.Run(method, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			config := `
+notifier:
+  platforms:
+    kick:
+      enabled: yes
+      method: "` + method + `"
+      channel: destiny
+      quality: best
+      refresh_time: 5
+    rumble:
+      enabled: yes
+      method: "` + method + `"
+      channel: Destiny
+      quality: best
+      refresh_time: 5
+nats:
+  host: localhost
+  topic: archiver
+`
+			if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := Load(misc.WithConfigFile(path), misc.WithoutConnect())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Platforms.Kick.Method != "scraper" || cfg.Platforms.Rumble.Method != "scraper" {
+				t.Errorf("kick method = %q, rumble method = %q, want scraper", cfg.Platforms.Kick.Method, cfg.Platforms.Rumble.Method)
+			}
+		})
+	}
+}
+
+func TestLoadIgnoresOtherServicesVariables(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	config := `
+notifier:
+  platforms:
+    kick:
+      enabled: yes
+      channel: ${KICK_CHANNEL:-destiny}
+      quality: best
+      refresh_time: 5
+uploader:
+  sqlite:
+    uri: ${UPLOADER_DB}
+nats:
+  host: localhost
+  topic: archiver
+`
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(misc.WithConfigFile(path), misc.WithoutConnect())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Platforms.Kick.Channel != "destiny" {
+		t.Errorf("kick channel = %q, want destiny", cfg.Platforms.Kick.Channel)
+	}
+}
+
+func TestLoadRejectsUnknownDownloader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	config := `
+notifier:
+  platforms:
+    kick:
+      enabled: yes
+      channel: destiny
+      quality: best
+      refresh_time: 5
+      downloader: ytarchive
+nats:
+  host: localhost
+  topic: archiver
+`
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Load(misc.WithConfigFile(path), misc.WithoutConnect())
+	var verr *misc.ValidationError
+	if !errors.As(err, &verr) || len(verr.Errors) != 1 || verr.Errors[0].Key != "notifier.platforms.kick.downloader" {
+		t.Fatalf("Load() = %v, want an error for notifier.platforms.kick.downloader", err)
+	}
+}
@@ -0,0 +1,96 @@
+package unified
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/DggHQ/dggarchiver-config/misc"
+)
+
+// loadAll loads the config of every service from path.
+func loadAll(t *testing.T, path string) map[string]any {
+	t.Helper()
+	configs := map[string]any{}
+	for _, svc := range Services {
+		cfg, err := svc.Load(misc.WithConfigFile(path), misc.WithoutConnect())
+		if err != nil {
+			t.Fatalf("%s: %v", svc.Name, err)
+		}
+		configs[svc.Name] = cfg
+	}
+	return configs
+}
+
+// TestFormats checks that the same config written in YAML, JSON and TOML
+// loads into identical configs.
+func TestFormats(t *testing.T) {
+	want := loadAll(t, filepath.Join("testdata", "config.yaml"))
+	for _, file := range []string{"config.json", "config.toml"} {
+		t.Run(file, func(t *testing.T) {
+			got := loadAll(t, filepath.Join("testdata", file))
+			for name := range want {
+				if !reflect.DeepEqual(got[name], want[name]) {
+					t.Errorf("%s differs from config.yaml:\n%v\nwant:\n%v", name, got[name], want[name])
+				}
+			}
+		})
+	}
+}
+
+// TestFormatsRoundTrip checks that a config converted to another format
+// with misc.EncodeConfig loads into the same configs.
+func TestFormatsRoundTrip(t *testing.T) {
+	path := filepath.Join("testdata", "config.yaml")
+	want := loadAll(t, path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := misc.ParseConfig(data, misc.FormatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{misc.FormatYAML, misc.FormatJSON, misc.FormatTOML} {
+		t.Run(format, func(t *testing.T) {
+			out, err := misc.EncodeConfig(node, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			converted := filepath.Join(t.TempDir(), "config."+format)
+			if err := os.WriteFile(converted, out, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			got := loadAll(t, converted)
+			for name := range want {
+				if !reflect.DeepEqual(got[name], want[name]) {
+					t.Errorf("%s differs once converted to %s:\n%s", name, format, out)
+				}
+			}
+		})
+	}
+}
+
+// TestConfigFormat checks that $CONFIG_FORMAT sets the format of a config
+// file without a known extension.
+func TestConfigFormat(t *testing.T) {
+	want := loadAll(t, filepath.Join("testdata", "config.yaml"))
+	data, err := os.ReadFile(filepath.Join("testdata", "config.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("CONFIG_FORMAT", "toml")
+	got := loadAll(t, path)
+	for name := range want {
+		if !reflect.DeepEqual(got[name], want[name]) {
+			t.Errorf("%s differs from config.yaml", name)
+		}
+	}
+}
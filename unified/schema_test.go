@@ -0,0 +1,119 @@
+package unified
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/DggHQ/dggarchiver-config/misc"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// TestSchemaJSON keeps testdata/schema.json, the schema published for
+// editors, in sync with the config structs. Run go test -update after
+// changing them.
+func TestSchemaJSON(t *testing.T) {
+	got, err := SchemaJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, '\n')
+
+	golden := filepath.Join("testdata", "schema.json")
+	if *update {
+		if err := os.WriteFile(golden, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("SchemaJSON() differs from %s, run go test ./unified -update and review the diff", golden)
+	}
+}
+
+// TestSchemaTags checks that the default and validate tags of every config
+// field are reflected in the schema.
+func TestSchemaTags(t *testing.T) {
+	schema := Schema()
+	for _, svc := range Services {
+		misc.WalkTypes(reflect.TypeOf(svc.Config()), func(path []string, field reflect.StructField) {
+			key := strings.Join(path, ".")
+			parent := schemaAt(schema, path[:len(path)-1])
+			if parent == nil {
+				t.Errorf("%s: no schema for its section", key)
+				return
+			}
+			name := path[len(path)-1]
+			fs := parent.Properties[name]
+			if fs == nil {
+				t.Errorf("%s: no schema", key)
+				return
+			}
+
+			if def, ok := field.Tag.Lookup("default"); ok {
+				got := fs.Default
+				if items, ok := fs.AdditionalProperties.(*misc.Schema); ok {
+					got = items.Default
+				}
+				if got == nil {
+					t.Errorf("%s: default %q missing from the schema", key, def)
+				}
+			}
+
+			rules := parent
+			if parent.Then != nil {
+				rules = parent.Then
+			}
+			for _, r := range misc.Rules(field) {
+				switch r.Name {
+				case "required":
+					if !slices.Contains(rules.Required, name) {
+						t.Errorf("%s: not required by the schema", key)
+					}
+				case "required_if":
+					if !slices.ContainsFunc(rules.AllOf, func(s *misc.Schema) bool {
+						return s.Then != nil && slices.Contains(s.Then.Required, name)
+					}) {
+						t.Errorf("%s: not conditionally required by the schema", key)
+					}
+				case "oneof":
+					for _, v := range strings.Fields(r.Param) {
+						if !slices.Contains(fs.Enum, any(v)) {
+							t.Errorf("%s: %s missing from the schema enum %v", key, v, fs.Enum)
+						}
+					}
+				case "min":
+					if minimum, _ := strconv.ParseInt(r.Param, 10, 64); fs.Minimum == nil || *fs.Minimum != minimum {
+						t.Errorf("%s: minimum %s missing from the schema", key, r.Param)
+					}
+				case "url":
+					if fs.Format != "uri" {
+						t.Errorf("%s: schema format is %q, want uri", key, fs.Format)
+					}
+				default:
+					t.Errorf("%s: rule %s isn't reflected in the schema", key, r.Name)
+				}
+			}
+		})
+	}
+}
+
+// schemaAt returns the schema of the section at path.
+func schemaAt(s *misc.Schema, path []string) *misc.Schema {
+	for _, name := range path {
+		if s = s.Properties[name]; s == nil {
+			return nil
+		}
+	}
+	return s
+}
@@ -0,0 +1,101 @@
+// Package unified works on the config file shared by every dggarchiver
+// service.
+package unified
+
+import (
+	"encoding/json"
+	"slices"
+
+	"github.com/DggHQ/dggarchiver-config/controller"
+	"github.com/DggHQ/dggarchiver-config/misc"
+	"github.com/DggHQ/dggarchiver-config/notifier"
+	"github.com/DggHQ/dggarchiver-config/uploader"
+)
+
+// Service is a dggarchiver service configured by config.yaml.
+type Service struct {
+	Name string
+	Load func(opts ...misc.Option) (any, error)
+	// Config returns an empty config of the service, used to describe its
+	// keys.
+	Config func() any
+}
+
+var Services = []Service{
+	{
+		Name:   "controller",
+		Load:   func(opts ...misc.Option) (any, error) { return controller.Load(opts...) },
+		Config: func() any { return &controller.Config{} },
+	},
+	{
+		Name:   "notifier",
+		Load:   func(opts ...misc.Option) (any, error) { return notifier.Load(opts...) },
+		Config: func() any { return &notifier.Config{} },
+	},
+	{
+		Name:   "uploader",
+		Load:   func(opts ...misc.Option) (any, error) { return uploader.Load(opts...) },
+		Config: func() any { return &uploader.Config{} },
+	},
+}
+
+// SecretKeys returns the dotted keys of the values tagged secret in the
+// configs of every service.
+func SecretKeys() []string {
+	var keys []string
+	for _, svc := range Services {
+		for _, info := range misc.Describe(svc.Config()) {
+			if info.Secret && !slices.Contains(keys, info.Key) {
+				keys = append(keys, info.Key)
+			}
+		}
+	}
+	return keys
+}
+
+// Schema returns the JSON Schema of config.yaml, covering the sections of
+// every service.
+func Schema() *misc.Schema {
+	s := &misc.Schema{
+		Schema:               "https://json-schema.org/draft/2020-12/schema",
+		Title:                "dggarchiver config",
+		Type:                 "object",
+		Properties:           map[string]*misc.Schema{},
+		AdditionalProperties: false,
+	}
+	for _, svc := range Services {
+		for name, section := range misc.JSONSchema(svc.Config()).Properties {
+			s.Properties[name] = section
+		}
+	}
+	s.Properties["include"] = &misc.Schema{
+		Description: "files or directories merged before this file, relative to it",
+		Type:        "array",
+		Items:       &misc.Schema{Type: "string"},
+	}
+	s.Properties["profiles"] = &misc.Schema{
+		Description:          "named overrides of the config, merged over it when selected by CONFIG_PROFILE",
+		Type:                 "object",
+		AdditionalProperties: &misc.Schema{Type: "object"},
+	}
+	s.Properties["sops"] = &misc.Schema{
+		Description: "metadata of a file encrypted by SOPS, removed once the file is decrypted",
+		Type:        "object",
+	}
+	s.Properties["vars"] = &misc.Schema{
+		Description:          "variables referenced by ${NAME} in config values",
+		Type:                 "object",
+		AdditionalProperties: &misc.Schema{Type: "string"},
+	}
+	s.Properties["version"] = &misc.Schema{
+		Description: "version of the config file format, older files are migrated when loaded",
+		Type:        "integer",
+		Default:     misc.ConfigVersion,
+	}
+	return s
+}
+
+// SchemaJSON returns the indented JSON encoding of Schema.
+func SchemaJSON() ([]byte, error) {
+	return json.MarshalIndent(Schema(), "", "  ")
+}
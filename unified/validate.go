@@ -0,0 +1,186 @@
+package unified
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/DggHQ/dggarchiver-config/controller"
+	"github.com/DggHQ/dggarchiver-config/misc"
+	"github.com/DggHQ/dggarchiver-config/notifier"
+	"github.com/DggHQ/dggarchiver-config/uploader"
+)
+
+// Report is the outcome of ValidateAll.
+type Report struct {
+	// Configs holds the valid config of every service with a section in
+	// the config, by service name.
+	Configs map[string]any
+	// Warnings lists the settings that are valid but likely mistakes.
+	Warnings []*misc.FieldError
+}
+
+// ValidateAll validates the config of every service with a section in the
+// config without connecting to anything, then checks the settings the
+// services must agree on. Every error is reported in a single
+// *misc.ValidationError.
+func ValidateAll(opts ...misc.Option) (*Report, error) {
+	opts = append(opts[:len(opts):len(opts)], misc.WithoutConnect())
+	doc, err := misc.NewOptions(opts...).ReadConfig()
+	if err != nil {
+		return nil, err
+	}
+	positions := doc.Positions()
+
+	report := &Report{Configs: map[string]any{}}
+	var verr misc.ValidationError
+	present := map[string]bool{}
+	for _, svc := range Services {
+		if present[svc.Name] = hasSection(doc, svc.Name); !present[svc.Name] {
+			continue
+		}
+		cfg, err := svc.Load(opts...)
+		var serr *misc.ValidationError
+		switch {
+		case errors.As(err, &serr):
+			for _, fe := range serr.Errors {
+				// Shared sections are reported by every service
+				if !hasError(&verr, fe) {
+					verr.Errors = append(verr.Errors, fe)
+				}
+			}
+		case err != nil:
+			verr.Add(svc.Name, err)
+		default:
+			report.Configs[svc.Name] = cfg
+		}
+	}
+
+	report.check(present, &verr)
+	for _, w := range report.Warnings {
+		w.Position = positions.Find(w.Key)
+	}
+	return report, positions.Locate(verr.Err())
+}
+
+func hasSection(doc *misc.Document, name string) bool {
+	for i := 0; i+1 < len(doc.Root.Content); i += 2 {
+		if doc.Root.Content[i].Value == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasError(verr *misc.ValidationError, fe *misc.FieldError) bool {
+	for _, e := range verr.Errors {
+		if e.Key == fe.Key && e.Err.Error() == fe.Err.Error() {
+			return true
+		}
+	}
+	return false
+}
+
+type platform struct {
+	name       string
+	enabled    bool
+	downloader string
+	tags       []string
+}
+
+// check checks the settings the services must agree on, among the services
+// in present whose config is valid.
+func (r *Report) check(present map[string]bool, verr *misc.ValidationError) {
+	ctrl, _ := r.Configs["controller"].(*controller.Config)
+	ntf, _ := r.Configs["notifier"].(*notifier.Config)
+	upl, _ := r.Configs["uploader"].(*uploader.Config)
+
+	// The services read the same nats section, so an invalid topic is
+	// reported once. Drift compares the topics of the running services.
+	for _, name := range []string{"controller", "notifier", "uploader"} {
+		if cfg, ok := r.Configs[name].(misc.Validatable); ok {
+			if err := checkSubject(cfg.NATSConfig().Topic); err != nil {
+				fe := &misc.FieldError{Key: "nats.topic", Err: err}
+				if !hasError(verr, fe) {
+					verr.Errors = append(verr.Errors, fe)
+				}
+			}
+		}
+	}
+
+	if ntf == nil {
+		return
+	}
+	var platforms []platform
+	p := ntf.Platforms
+	for _, pl := range []platform{
+		{"youtube", p.YouTube.Enabled, p.YouTube.Downloader, p.YouTube.Tags},
+		{"rumble", p.Rumble.Enabled, p.Rumble.Downloader, p.Rumble.Tags},
+		{"kick", p.Kick.Enabled, p.Kick.Downloader, p.Kick.Tags},
+	} {
+		if pl.enabled {
+			platforms = append(platforms, pl)
+		}
+	}
+
+	// Workers started by the controller download the streams the notifier
+	// finds
+	if len(platforms) > 0 && !present["controller"] {
+		verr.Add("controller.worker_image", fmt.Errorf("%w, the notifier has platforms enabled", misc.ErrNotSet))
+	}
+	if ctrl != nil {
+		for _, pl := range platforms {
+			if !slices.Contains(ctrl.WorkerDownloaders, pl.downloader) {
+				verr.Add("notifier.platforms."+pl.name+".downloader", fmt.Errorf("%w: %s isn't supported by the worker image, see controller.worker_downloaders", misc.ErrInvalid, pl.downloader))
+			}
+		}
+	}
+
+	// Uploader filters are matched against the tags the notifier sets
+	var tags []string
+	for _, pl := range platforms {
+		tags = append(tags, pl.tags...)
+	}
+	if upl == nil || len(tags) == 0 {
+		return
+	}
+	filters := make([]string, 0, len(upl.Filters))
+	for filter := range upl.Filters {
+		filters = append(filters, filter)
+	}
+	sort.Strings(filters)
+	for _, filter := range filters {
+		re, err := regexp.Compile(filter)
+		if err != nil {
+			continue
+		}
+		if !slices.ContainsFunc(tags, re.MatchString) {
+			r.Warnings = append(r.Warnings, &misc.FieldError{
+				Key: "uploader.filters",
+				Err: fmt.Errorf("filter %q matches none of the notifier tags %s", filter, strings.Join(tags, ", ")),
+			})
+		}
+	}
+}
+
+// checkSubject checks that topic can prefix the NATS subjects the services
+// communicate on.
+func checkSubject(topic string) error {
+	if topic == "" {
+		return nil
+	}
+	for _, token := range strings.Split(topic, ".") {
+		switch {
+		case token == "":
+			return fmt.Errorf("%w: %q has an empty subject token", misc.ErrInvalid, topic)
+		case strings.ContainsAny(token, " \t\r\n"):
+			return fmt.Errorf("%w: %q contains whitespace", misc.ErrInvalid, topic)
+		case token == "*" || token == ">":
+			return fmt.Errorf("%w: %q contains a wildcard", misc.ErrInvalid, topic)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package unified
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DggHQ/dggarchiver-config/misc"
+)
+
+const validateNotifier = `
+notifier:
+  platforms:
+    kick:
+      enabled: yes
+      downloader: N_m3u8DL-RE
+      channel: destiny
+      quality: best
+      refresh_time: 5
+      tags: [live]
+`
+
+const validateController = `
+controller:
+  worker_image: ghcr.io/dgghq/dggarchiver-worker:main
+  docker:
+    enabled: yes
+    network: dggarchiver-network
+    mount:
+      type: volume
+      source: dggarchiver-vods
+`
+
+const validateUploader = `
+uploader:
+  platforms:
+    lbry:
+      enabled: yes
+      uri: https://example.com/
+      author: example
+      channel_name: example
+  sqlite:
+    uri: vods.sqlite
+`
+
+const validateNATS = `
+nats:
+  host: localhost
+  topic: archiver
+`
+
+func TestValidateAll(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		errors   []string
+		warnings []string
+	}{
+		{
+			name:   "valid",
+			config: validateNotifier + validateController + validateUploader + validateNATS,
+		},
+		{
+			name:   "missing controller",
+			config: validateNotifier + validateNATS,
+			errors: []string{"controller.worker_image"},
+		},
+		{
+			name:   "no platforms without controller",
+			config: validateUploader + validateNATS,
+		},
+		{
+			name:   "unsupported downloader",
+			config: validateNotifier + validateController + "  worker_downloaders: [yt-dlp]\n" + validateNATS,
+			errors: []string{"notifier.platforms.kick.downloader"},
+		},
+		{
+			name:     "filter matching no tag",
+			config:   validateNotifier + validateController + validateUploader + "  filters:\n    live: upload\n    '(?i)rerun': skip\n" + validateNATS,
+			warnings: []string{"uploader.filters"},
+		},
+		{
+			name:   "shared nats errors reported once",
+			config: validateNotifier + validateController + validateUploader + "nats:\n  topic: archiver\n",
+			errors: []string{"nats.host"},
+		},
+		{
+			name:   "invalid topic reported once",
+			config: validateNotifier + validateController + validateUploader + "nats:\n  host: localhost\n  topic: archiver.*\n",
+			errors: []string{"nats.topic"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.config), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			report, err := ValidateAll(misc.WithConfigFile(path))
+			var keys []string
+			var verr *misc.ValidationError
+			switch {
+			case errors.As(err, &verr):
+				for _, fe := range verr.Errors {
+					keys = append(keys, fe.Key)
+					if fe.File != path {
+						t.Errorf("error %v isn't located in %s", fe, path)
+					}
+				}
+			case err != nil:
+				t.Fatal(err)
+			}
+			if strings.Join(keys, ",") != strings.Join(tt.errors, ",") {
+				t.Errorf("ValidateAll() = %v, want errors on %q", err, tt.errors)
+			}
+			if err != nil {
+				return
+			}
+
+			keys = nil
+			for _, w := range report.Warnings {
+				keys = append(keys, w.Key)
+				if w.File != path || w.Line == 0 {
+					t.Errorf("warning %v isn't located in %s", w, path)
+				}
+			}
+			if strings.Join(keys, ",") != strings.Join(tt.warnings, ",") {
+				t.Errorf("warnings = %v, want %q", report.Warnings, tt.warnings)
+			}
+		})
+	}
+}
@@ -1,55 +1,61 @@
 package uploader
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
-	"os"
+	"regexp"
+	"sort"
+	"time"
 
-	"github.com/containrrr/shoutrrr"
 	"github.com/glebarez/sqlite"
-	"github.com/joho/godotenv"
-	"gopkg.in/yaml.v2"
 	"gorm.io/gorm"
 
 	"github.com/DggHQ/dggarchiver-config/misc"
 	dggarchivermodel "github.com/DggHQ/dggarchiver-model"
 )
 
+var ErrNoPlatforms = errors.New("no upload platforms enabled")
+
 type SQLiteConfig struct {
-	URI string `yaml:"uri"`
-	DB  *gorm.DB
+	URI     string        `yaml:"uri" secret:"-" validate:"required" desc:"path of the SQLite database of uploaded VODs"`
+	Timeout time.Duration `yaml:"timeout" default:"10s" desc:"time to wait for the database when opening it"`
+	DB      *gorm.DB      `yaml:"-"`
 }
 
 type OdyseeConfig struct {
-	Enabled   bool
-	Email     string `yaml:"email"`
-	Password  string `yaml:"password"`
-	ChannelID string `yaml:"channel_id"`
+	Enabled   bool   `desc:"upload VODs to Odysee"`
+	Email     string `yaml:"email" validate:"required" desc:"email of the Odysee account"`
+	Password  string `yaml:"password" secret:"true" validate:"required" desc:"password of the Odysee account"`
+	ChannelID string `yaml:"channel_id" validate:"required" desc:"ID of the Odysee channel VODs are uploaded to"`
 }
 
 type LBRYConfig struct {
-	Enabled     bool
-	URI         string `yaml:"uri"`
-	Author      string `yaml:"author"`
-	ChannelName string `yaml:"channel_name"`
+	Enabled     bool   `desc:"upload VODs through an LBRY daemon"`
+	URI         string `yaml:"uri" validate:"required,url" desc:"URL of the LBRY daemon API"`
+	Author      string `yaml:"author" validate:"required" desc:"author set on uploaded VODs"`
+	ChannelName string `yaml:"channel_name" validate:"required" desc:"LBRY channel VODs are uploaded to"`
 }
 
 type RumbleConfig struct {
-	Enabled  bool
-	Login    string `yaml:"login"`
-	Password string `yaml:"password"`
+	Enabled  bool   `desc:"upload VODs to Rumble"`
+	Login    string `yaml:"login" validate:"required" desc:"login of the Rumble account"`
+	Password string `yaml:"password" secret:"true" validate:"required" desc:"password of the Rumble account"`
 }
 
 type Uploader struct {
-	Verbose   bool
+	Verbose   bool `desc:"enable debug logging"`
 	Platforms struct {
-		LBRY   LBRYConfig   `yaml:"lbry"`
-		Odysee OdyseeConfig `yaml:"odysee"`
-		Rumble RumbleConfig `yaml:"rumble"`
-	}
-	ParallelUploads bool               `yaml:"parallel_uploads"`
-	Filters         map[string]string  `yaml:"filters"`
-	SQLite          SQLiteConfig       `yaml:"sqlite"`
-	Notifications   misc.Notifications `yaml:"notifications"`
+		LBRY   LBRYConfig   `yaml:"lbry" desc:"LBRY upload settings"`
+		Odysee OdyseeConfig `yaml:"odysee" desc:"Odysee upload settings"`
+		Rumble RumbleConfig `yaml:"rumble" desc:"Rumble upload settings"`
+	} `desc:"platforms VODs are uploaded to"`
+	ParallelUploads bool               `yaml:"parallel_uploads" desc:"upload to every platform at the same time"`
+	Filters         map[string]string  `yaml:"filters" default:"skip" desc:"regular expressions mapped to the action taken on matching VODs"`
+	SQLite          SQLiteConfig       `yaml:"sqlite" desc:"database of uploaded VODs"`
+	Notifications   misc.Notifications `yaml:"notifications" desc:"notifications sent by the uploader"`
+	ShutdownGrace   time.Duration      `yaml:"shutdown_grace" default:"30s" desc:"time given to the service to shut down on SIGINT or SIGTERM"`
 }
 
 type Config struct {
@@ -57,139 +63,134 @@ type Config struct {
 	NATS      misc.NATSConfig `yaml:"nats"`
 }
 
+// LogValue implements slog.LogValuer, masking passwords, tokens and the
+// credentials in service URLs.
+func (cfg Config) LogValue() slog.Value {
+	return misc.RedactedValue(cfg)
+}
+
+func (cfg Config) String() string {
+	return misc.RedactedString(cfg)
+}
+
+// New loads the uploader config, logging the error and exiting on failure.
 func New() *Config {
-	var (
-		err error
-		cfg = Config{}
-		lvl slog.LevelVar
-	)
+	var lvl slog.LevelVar
 
 	misc.SetupSlog(&lvl)
 
-	_ = godotenv.Load()
-
-	configFile := os.Getenv("CONFIG")
-	if configFile == "" {
-		configFile = "config.yaml"
-	}
-	configBytes, err := os.ReadFile(configFile)
+	cfg, err := Load(misc.WithLevel(&lvl))
 	if err != nil {
-		slog.Error("unable to load config", slog.Any("err", err))
-		os.Exit(1)
+		misc.LogFatal(err)
 	}
 
-	err = yaml.Unmarshal(configBytes, &cfg)
-	if err != nil {
-		slog.Error("unable to unmarshall config yaml", slog.Any("err", err))
-		os.Exit(1)
-	}
+	return cfg
+}
 
-	if cfg.Uploader.Verbose {
-		lvl.Set(slog.LevelDebug)
-	}
+// Load reads and validates the uploader config and connects to the
+// configured services, see misc.Load.
+func Load(opts ...misc.Option) (*Config, error) {
+	return misc.Load(&Config{}, opts...)
+}
 
-	cfg.Uploader.initialize()
+// Watch returns a watcher that reloads cfg whenever one of its config files
+// changes or SIGHUP is received, see misc.Watch.
+func Watch(cfg *Config, opts ...misc.Option) *misc.Watcher[Config] {
+	return misc.Watch(cfg, opts...)
+}
 
-	// NATS Host Name or IP
-	if cfg.NATS.Host == "" {
-		slog.Error("config variable not set", slog.String("var", "nats:host"))
-		os.Exit(1)
-	}
-	// NATS Topic Name
-	if cfg.NATS.Topic == "" {
-		slog.Error("config variable not set", slog.String("var", "nats:topic"))
-		os.Exit(1)
-	}
-	cfg.NATS.Load()
+// Validate implements misc.Validatable.
+func (cfg *Config) Validate(v *misc.Validator) {
+	cfg.Uploader.validate(v)
+}
 
-	return &cfg
+// Connect implements misc.Validatable.
+func (cfg *Config) Connect(ctx context.Context) error {
+	return misc.ConnectDependency(ctx, "sqlite", cfg.Uploader.SQLite.Timeout, cfg.Uploader.loadSQLite)
 }
 
-func (uploader *Uploader) initialize() {
-	// SQLite
-	if uploader.SQLite.URI == "" {
-		slog.Error("config variable not set", slog.String("var", "uploader:sqlite:uri"))
-		os.Exit(1)
+// Disconnect implements misc.Validatable, it closes the SQLite database.
+func (cfg *Config) Disconnect() error {
+	if cfg.Uploader == nil || cfg.SQLite.DB == nil {
+		return nil
 	}
-	uploader.loadSQLite()
-
-	if !uploader.Platforms.LBRY.Enabled && !uploader.Platforms.Rumble.Enabled && !uploader.Platforms.Odysee.Enabled {
-		slog.Error("no upload platforms enabled")
-		os.Exit(1)
+	db, err := cfg.SQLite.DB.DB()
+	cfg.SQLite.DB = nil
+	if err == nil {
+		err = db.Close()
 	}
-
-	// LBRY
-	if uploader.Platforms.LBRY.Enabled {
-		if uploader.Platforms.LBRY.URI == "" {
-			slog.Error("config variable not set", slog.String("var", "uploader:platforms:lbry:uri"))
-			os.Exit(1)
-		}
-		if uploader.Platforms.LBRY.Author == "" {
-			slog.Error("config variable not set", slog.String("var", "uploader:platforms:lbry:author"))
-			os.Exit(1)
-		}
-		if uploader.Platforms.LBRY.ChannelName == "" {
-			slog.Error("config variable not set", slog.String("var", "uploader:platforms:lbry:channel_name"))
-			os.Exit(1)
-		}
+	if err != nil {
+		return fmt.Errorf("unable to close sqlite db: %w", err)
 	}
+	return nil
+}
 
-	// Odysee
-	if uploader.Platforms.Odysee.Enabled {
-		if uploader.Platforms.Odysee.Email == "" {
-			slog.Error("config variable not set", slog.String("var", "uploader:platforms:odysee:email"))
-			os.Exit(1)
-		}
-		if uploader.Platforms.Odysee.Password == "" {
-			slog.Error("config variable not set", slog.String("var", "uploader:platforms:odysee:password"))
-			os.Exit(1)
-		}
-		if uploader.Platforms.Odysee.ChannelID == "" {
-			slog.Error("config variable not set", slog.String("var", "uploader:platforms:odysee:channel_id"))
-			os.Exit(1)
-		}
+// Close drains the NATS connection, then closes the SQLite database.
+func (cfg *Config) Close(ctx context.Context) error {
+	var errs []error
+	if err := cfg.NATS.Close(ctx); err != nil {
+		errs = append(errs, err)
 	}
+	if err := cfg.Disconnect(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
 
-	// Rumble
-	if uploader.Platforms.Rumble.Enabled {
-		if uploader.Platforms.Rumble.Login == "" {
-			slog.Error("config variable not set", slog.String("var", "uploader:platforms:rumble:login"))
-			os.Exit(1)
-		}
-		if uploader.Platforms.Rumble.Password == "" {
-			slog.Error("config variable not set", slog.String("var", "uploader:platforms:rumble:password"))
-			os.Exit(1)
-		}
+// NATSConfig implements misc.Validatable.
+func (cfg *Config) NATSConfig() *misc.NATSConfig {
+	return &cfg.NATS
+}
+
+// ServiceName implements misc.Validatable.
+func (cfg *Config) ServiceName() string {
+	return "uploader"
+}
+
+// IsVerbose implements misc.Validatable.
+func (cfg *Config) IsVerbose() bool {
+	return cfg.Uploader.Verbose
+}
+
+func (uploader *Uploader) validate(v *misc.Validator) {
+	if !uploader.Platforms.LBRY.Enabled && !uploader.Platforms.Rumble.Enabled && !uploader.Platforms.Odysee.Enabled {
+		v.Add(&uploader.Platforms, ErrNoPlatforms)
 	}
 
-	for k, v := range uploader.Filters {
-		if v == "" {
-			uploader.Filters[k] = "skip"
+	filters := make([]string, 0, len(uploader.Filters))
+	for filter := range uploader.Filters {
+		filters = append(filters, filter)
+	}
+	sort.Strings(filters)
+	for _, filter := range filters {
+		if _, err := regexp.Compile(filter); err != nil {
+			v.Add(&uploader.Filters, fmt.Errorf("invalid filter %q: %w", filter, err))
 		}
 	}
 
 	// Notifications
-	if uploader.Notifications.Enabled() {
-		var err error
-		uploader.Notifications.Sender, err = shoutrrr.CreateSender(uploader.Notifications.Services...)
-		if err != nil {
-			slog.Error("unable to create notification sender", slog.Any("err", err))
-			os.Exit(1)
-		}
+	if err := uploader.Notifications.Load(); err != nil {
+		v.Add(&uploader.Notifications.Services, err)
 	}
 }
 
-func (uploader *Uploader) loadSQLite() {
+func (uploader *Uploader) loadSQLite(ctx context.Context) error {
 	var err error
 
-	uploader.SQLite.DB, err = gorm.Open(sqlite.Open(uploader.SQLite.URI), &gorm.Config{})
+	uploader.SQLite.DB, err = gorm.Open(sqlite.Open(uploader.SQLite.URI), &gorm.Config{DisableAutomaticPing: true})
 	if err != nil {
-		slog.Error("unable to open sqlite db", slog.Any("err", err))
-		os.Exit(1)
+		return fmt.Errorf("unable to open sqlite db: %w", err)
+	}
+	db, err := uploader.SQLite.DB.DB()
+	if err != nil {
+		return fmt.Errorf("unable to open sqlite db: %w", err)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		return fmt.Errorf("unable to open sqlite db: %w", err)
 	}
 
-	if err := uploader.SQLite.DB.AutoMigrate(&dggarchivermodel.UploadedVOD{}); err != nil {
-		slog.Error("unable to migrate sqlite db", slog.Any("err", err))
-		os.Exit(1)
+	if err := uploader.SQLite.DB.WithContext(ctx).AutoMigrate(&dggarchivermodel.UploadedVOD{}); err != nil {
+		return fmt.Errorf("unable to migrate sqlite db: %w", err)
 	}
+	return nil
 }